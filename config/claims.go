@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+)
+
+// claimMapping maps a claim to a header, in the claim mapping file it is either the header
+// or an object with the header, whether the claim is required and how arrays and objects are rendered
+type claimMapping struct {
+	Header    string               `json:"header"`
+	Required  bool                 `json:"required"`
+	Array     decoder.ArrayFormat  `json:"array"`
+	Object    decoder.ObjectFormat `json:"object"`
+	Delimiter string               `json:"delimiter"`
+}
+
+func (m *claimMapping) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &m.Header); err == nil {
+		return nil
+	}
+	type plainClaimMapping claimMapping
+	return json.Unmarshal(data, (*plainClaimMapping)(m))
+}
+
+type claimMappingsT map[string]claimMapping
+
+// headers returns the mapping from claim to header for all claims with a header
+func (c claimMappingsT) headers() map[string]string {
+	headers := make(map[string]string)
+	for claim, mapping := range c {
+		if mapping.Header != "" {
+			headers[claim] = mapping.Header
+		}
+	}
+	return headers
+}
+
+// formats returns the claim formats by header of the claims with a header and an array or object format
+func (c claimMappingsT) formats() (map[string]decoder.ClaimFormat, error) {
+	formats := make(map[string]decoder.ClaimFormat)
+	for claim, mapping := range c {
+		if mapping.Header == "" || (mapping.Array == "" && mapping.Object == "") {
+			continue
+		}
+		format := decoder.ClaimFormat{Array: mapping.Array, Object: mapping.Object, Delimiter: mapping.Delimiter}
+		if err := format.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid format of claim mapping %s: %w", claim, err)
+		}
+		formats[mapping.Header] = format
+	}
+	return formats, nil
+}
+
+// merge returns the claim mappings overridden by the other claim mappings
+func (c claimMappingsT) merge(other claimMappingsT) claimMappingsT {
+	merged := make(claimMappingsT)
+	for claim, mapping := range c {
+		merged[claim] = mapping
+	}
+	for claim, mapping := range other {
+		merged[claim] = mapping
+	}
+	return merged
+}
+
+// required returns the claims that are required to be in the token
+func (c claimMappingsT) required() (claims []string) {
+	for claim, mapping := range c {
+		if mapping.Required {
+			claims = append(claims, claim)
+		}
+	}
+	return
+}
+
+func (c claimMappingsT) fromFile(path string) error {
+	return readJSONFile(path, &c)
+}
+
+func (c claimMappingsT) fromString(val string) error {
+	mappings := strings.Split(val, ",")
+	for _, mapping := range mappings {
+		if len(mapping) == 0 {
+			continue
+		}
+		lastInd := strings.LastIndex(mapping, ":")
+		if lastInd == -1 {
+			return fmt.Errorf("unexpected number of ':' in claim mapping '%s'", mapping)
+		}
+		key := mapping[:lastInd]
+		value := mapping[lastInd+1:]
+		claimMapping := c[key]
+		claimMapping.Header = value
+		c[key] = claimMapping
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// getHTTPClient returns the client used for all outbound calls (JWKS, discovery and introspection),
+// it is created once from the env
+func (c *Config) getHTTPClient() *http.Client {
+	if c.httpClient != nil {
+		return c.httpClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = c.getClientTLSConfig()
+	if proxy := c.httpClientProxyURL.get(); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			panic(fmt.Errorf("unable to parse %s: %w", HTTPClientProxyURLEnv, err))
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+		log.Info().Str("proxy", proxyURL.Redacted()).Msg("sending outbound requests through proxy")
+	}
+	var roundTripper http.RoundTripper = transport
+	if auth := c.httpClientAuthHeader.get(); auth != "" {
+		hosts := c.getConfiguredHosts()
+		roundTripper = &authTransport{auth: auth, hosts: hosts, delegate: transport}
+		log.Info().Strs("hosts", hostList(hosts)).Msg("sending the Authorization header to the configured hosts")
+	}
+	c.httpClient = &http.Client{Transport: roundTripper, Timeout: c.httpClientTimeout.getDuration()}
+	return c.httpClient
+}
+
+func (c *Config) getClientTLSConfig() *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if path := c.httpClientCAFilePath.get(); path != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			panic(fmt.Errorf("unable to read CA bundle: %w", err))
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			panic(fmt.Errorf("no certificates found in CA bundle %s", path))
+		}
+		config.RootCAs = pool
+		log.Info().Str("path", path).Msg("trusting CA bundle for outbound requests")
+	}
+	certPath, keyPath := c.httpClientCertFilePath.get(), c.httpClientKeyFilePath.get()
+	if certPath != "" || keyPath != "" {
+		if certPath == "" || keyPath == "" {
+			panic(fmt.Errorf("both %s and %s are required for a client certificate", HTTPClientCertFileEnv, HTTPClientKeyFileEnv))
+		}
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			panic(fmt.Errorf("unable to load client certificate: %w", err))
+		}
+		config.Certificates = []tls.Certificate{cert}
+		log.Info().Str("path", certPath).Msg("presenting client certificate on outbound requests")
+	}
+	return config
+}
+
+// getConfiguredHosts returns the hosts of the configured JWKS, discovery and introspection urls,
+// hosts learned from a discovery document or a redirect are not included
+func (c *Config) getConfiguredHosts() map[string]bool {
+	urls := []string{c.jwksURL.get(), c.oidcIssuerURL.get(), c.introspectionURL.get()}
+	for _, iss := range c.getIssuers() {
+		urls = append(urls, iss.JwksURL)
+	}
+	hosts := make(map[string]bool)
+	for _, raw := range urls {
+		if raw == "" {
+			continue
+		}
+		parsed, err := url.Parse(raw)
+		if err != nil || parsed.Host == "" {
+			continue
+		}
+		hosts[strings.ToLower(parsed.Host)] = true
+	}
+	return hosts
+}
+
+func hostList(hosts map[string]bool) []string {
+	list := make([]string, 0, len(hosts))
+	for host := range hosts {
+		list = append(list, host)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// authTransport sets the Authorization header on requests to the configured hosts that don't have one already,
+// so the credential is not sent to other hosts like the target of a redirect
+type authTransport struct {
+	auth     string
+	hosts    map[string]bool
+	delegate http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" || !t.hosts[strings.ToLower(req.URL.Host)] {
+		return t.delegate.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", t.auth)
+	return t.delegate.RoundTrip(req)
+}
@@ -1,13 +1,16 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -19,6 +22,7 @@ import (
 
 	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
 	"github.com/dgraph-io/ristretto"
+	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/rs/zerolog"
 )
 
@@ -34,6 +38,10 @@ const (
 	TokenValidatedHeaderEnv     = "TOKEN_VALIDATED_HEADER_KEY"
 	TokenValidatedHeaderDefault = "jwt-token-validated"
 	AuthHeaderRequired          = "AUTH_HEADER_REQUIRED"
+	TokenCookiesEnv             = "TOKEN_COOKIE_NAMES"
+	TokenSourcesEnv             = "TOKEN_SOURCES"
+	WebSocketProtocolHeaderEnv  = "WEBSOCKET_PROTOCOL_HEADER_KEY"
+	WebSocketProtocolDefault    = "jwt-token-websocket-protocol"
 	AuthHeaderRequiredDefault   = "false"
 	PortEnv                     = "PORT"
 	PortDefault                 = "8080"
@@ -46,42 +54,185 @@ const (
 	CacheEnabledEnv             = "CACHE_ENABLED"
 	CacheEnabledDefault         = "true"
 	ClaimMappingsEnv            = "CLAIM_MAPPINGS"
+	AllowedIssuersEnv           = "ALLOWED_ISSUERS"
+	AllowedIssuersFileEnv       = "ALLOWED_ISSUERS_FILE_PATH"
+	ExpectedAudiencesEnv        = "EXPECTED_AUDIENCES"
+	AudienceMatchEnv            = "AUDIENCE_MATCH"
+	AudienceMatchDefault        = "any"
+	LeewayEnv                   = "CLOCK_SKEW_LEEWAY"
+	LeewayDefault               = "0s"
+	AllowedAlgorithmsEnv        = "ALLOWED_ALGORITHMS"
+	RequiredClaimsEnv           = "REQUIRED_CLAIMS"
+	MaxTokenLifetimeEnv         = "MAX_TOKEN_LIFETIME"
+	MaxTokenAgeEnv              = "MAX_TOKEN_AGE"
+	MaxAuthAgeEnv               = "MAX_AUTH_AGE"
+	DisabledDurationDefault     = "0s"
+	AccessTokenProfileEnv       = "ACCESS_TOKEN_PROFILE"
+	AccessTokenProfileDefault   = "false"
+	AccessTokenTypeEnv          = "ACCESS_TOKEN_TYPE"
+	AccessTokenTypeDefault      = decoder.AccessTokenType
+	IssuersFileEnv              = "ISSUERS_FILE_PATH"
+	OidcIssuerURLEnv            = "OIDC_ISSUER_URL"
+	OidcRefreshIntervalEnv      = "OIDC_DISCOVERY_REFRESH_INTERVAL"
+	OidcRefreshIntervalDefault  = "1h"
+	JwksFileEnv                 = "JWKS_FILE_PATH"
+	JwksEnv                     = "JWKS"
+	PemKeyFilesEnv              = "PEM_KEY_FILE_PATHS"
+	KeyFilePollIntervalEnv      = "KEY_FILE_POLL_INTERVAL"
+	KeyFilePollIntervalDefault  = "10s"
+	KeyRefreshIntervalEnv       = "JWKS_REFRESH_MIN_INTERVAL"
+	KeyRefreshIntervalDefault   = "30s"
+	JwksSnapshotDirEnv          = "JWKS_SNAPSHOT_DIR"
+	JwksMaxStalenessEnv         = "JWKS_MAX_STALENESS"
+	JwksRetiredKeyGraceEnv      = "JWKS_RETIRED_KEY_GRACE_PERIOD"
+	HmacSecretsEnv              = "HMAC_SECRETS"
+	HmacSecretFilesEnv          = "HMAC_SECRET_FILE_PATHS"
+	HmacIssuerEnv               = "HMAC_ISSUER"
+	DecryptionKeyFileEnv        = "JWE_DECRYPTION_KEY_FILE_PATH"
+	IntrospectionURLEnv         = "INTROSPECTION_URL"
+	IntrospectionClientIDEnv    = "INTROSPECTION_CLIENT_ID"
+	IntrospectionSecretEnv      = "INTROSPECTION_CLIENT_SECRET"
+	DPoPEnabledEnv              = "DPOP_ENABLED"
+	DPoPEnabledDefault          = "false"
+	DPoPProofMaxAgeEnv          = "DPOP_PROOF_MAX_AGE"
+	DPoPProofMaxAgeDefault      = "60s"
+	CertificateBindingEnv       = "CERTIFICATE_BOUND_TOKENS"
+	CertificateBindingDefault   = "false"
+	RevocationFileEnv           = "REVOCATION_LIST_FILE_PATH"
+	RevocationPollIntervalEnv   = "REVOCATION_LIST_POLL_INTERVAL"
+	RevocationPollDefault       = "10s"
+	HTTPClientTimeoutEnv        = "HTTP_CLIENT_TIMEOUT"
+	HTTPClientTimeoutDefault    = "10s"
+	HTTPClientCAFileEnv         = "HTTP_CLIENT_CA_FILE_PATH"
+	HTTPClientProxyURLEnv       = "HTTP_CLIENT_PROXY_URL"
+	HTTPClientCertFileEnv       = "HTTP_CLIENT_CERT_FILE_PATH"
+	HTTPClientKeyFileEnv        = "HTTP_CLIENT_KEY_FILE_PATH"
+	HTTPClientAuthHeaderEnv     = "HTTP_CLIENT_AUTH_HEADER"
 )
 
 // NewConfig creates a new Config from the current env
 func NewConfig() *Config {
 	var c Config
-	c.jwksURL = required(JwksURLEnv)
+	c.jwksURL = optional(JwksURLEnv)
+	c.jwksFilePath = optional(JwksFileEnv)
+	c.jwks = optional(JwksEnv)
+	c.pemKeyFilePaths = optional(PemKeyFilesEnv)
+	c.keyFilePollInterval = withDefault(KeyFilePollIntervalEnv, KeyFilePollIntervalDefault)
+	c.keyRefreshInterval = withDefault(KeyRefreshIntervalEnv, KeyRefreshIntervalDefault)
+	c.jwksSnapshotDir = optional(JwksSnapshotDirEnv)
+	c.jwksMaxStaleness = withDefault(JwksMaxStalenessEnv, DisabledDurationDefault)
+	c.jwksRetiredKeyGrace = withDefault(JwksRetiredKeyGraceEnv, DisabledDurationDefault)
+	c.hmacSecrets = optional(HmacSecretsEnv)
+	c.hmacSecretFilePaths = optional(HmacSecretFilesEnv)
+	c.hmacIssuer = optional(HmacIssuerEnv)
+	c.decryptionKeyFilePath = optional(DecryptionKeyFileEnv)
+	c.introspectionURL = optional(IntrospectionURLEnv)
+	c.introspectionClientID = optional(IntrospectionClientIDEnv)
+	c.introspectionSecret = optional(IntrospectionSecretEnv)
+	c.dpopEnabled = withDefault(DPoPEnabledEnv, DPoPEnabledDefault)
+	c.dpopProofMaxAge = withDefault(DPoPProofMaxAgeEnv, DPoPProofMaxAgeDefault)
+	c.certificateBinding = withDefault(CertificateBindingEnv, CertificateBindingDefault)
+	c.revocationFilePath = optional(RevocationFileEnv)
+	c.revocationPollInterval = withDefault(RevocationPollIntervalEnv, RevocationPollDefault)
+	c.httpClientTimeout = withDefault(HTTPClientTimeoutEnv, HTTPClientTimeoutDefault)
+	c.httpClientCAFilePath = optional(HTTPClientCAFileEnv)
+	c.httpClientProxyURL = optional(HTTPClientProxyURLEnv)
+	c.httpClientCertFilePath = optional(HTTPClientCertFileEnv)
+	c.httpClientKeyFilePath = optional(HTTPClientKeyFileEnv)
+	c.httpClientAuthHeader = optional(HTTPClientAuthHeaderEnv)
+	c.issuersFilePath = optional(IssuersFileEnv)
+	c.oidcIssuerURL = optional(OidcIssuerURLEnv)
+	c.oidcRefreshInterval = withDefault(OidcRefreshIntervalEnv, OidcRefreshIntervalDefault)
 	c.forceJwksOnStart = withDefault(ForceJwksOnStart, ForceJwksOnStartDefault)
 	c.claimMappingFilePath = withDefault(ClaimMappingFileEnv, ClaimMappingFileDefault)
 	c.authHeader = withDefault(AuthHeaderEnv, AuthHeaderDefault)
 	c.tokenValidatedHeader = withDefault(TokenValidatedHeaderEnv, TokenValidatedHeaderDefault)
 	c.authHeaderRequired = withDefault(AuthHeaderRequired, AuthHeaderRequiredDefault)
+	c.tokenCookies = optional(TokenCookiesEnv)
+	c.tokenSources = optional(TokenSourcesEnv)
+	c.wsProtocolHeader = withDefault(WebSocketProtocolHeaderEnv, WebSocketProtocolDefault)
 	c.port = withDefault(PortEnv, PortDefault)
 	c.logLevel = withDefault(LogLevelEnv, LogLevelDefault)
 	c.logType = withDefault(LogTypeEnv, LogTypeDefault)
 	c.maxCacheKeys = withDefault(MaxCacheKeysEnv, MaxCacheKeysDefault)
 	c.cacheEnabled = withDefault(CacheEnabledEnv, CacheEnabledDefault)
 	c.claimMappings = optional(ClaimMappingsEnv)
+	c.allowedIssuers = optional(AllowedIssuersEnv)
+	c.allowedIssuersFilePath = optional(AllowedIssuersFileEnv)
+	c.expectedAudiences = optional(ExpectedAudiencesEnv)
+	c.audienceMatch = withDefault(AudienceMatchEnv, AudienceMatchDefault)
+	c.leeway = withDefault(LeewayEnv, LeewayDefault)
+	c.allowedAlgorithms = optional(AllowedAlgorithmsEnv)
+	c.requiredClaims = optional(RequiredClaimsEnv)
+	c.maxTokenLifetime = withDefault(MaxTokenLifetimeEnv, DisabledDurationDefault)
+	c.maxTokenAge = withDefault(MaxTokenAgeEnv, DisabledDurationDefault)
+	c.maxAuthAge = withDefault(MaxAuthAgeEnv, DisabledDurationDefault)
+	c.accessTokenProfile = withDefault(AccessTokenProfileEnv, AccessTokenProfileDefault)
+	c.accessTokenType = withDefault(AccessTokenTypeEnv, AccessTokenTypeDefault)
 	c.keyCost = 100
 	return &c
 }
 
 // Config to bootstrap decoder server
 type Config struct {
-	jwksURL              envVar
-	forceJwksOnStart     envVar
-	claimMappingFilePath envVar
-	authHeader           envVar
-	tokenValidatedHeader envVar
-	authHeaderRequired   envVar
-	port                 envVar
-	logLevel             envVar
-	logType              envVar
-	maxCacheKeys         envVar
-	cacheEnabled         envVar
-	claimMappings        envVar
-	keyCost              int64
+	jwksURL                envVar
+	jwksFilePath           envVar
+	jwks                   envVar
+	pemKeyFilePaths        envVar
+	keyFilePollInterval    envVar
+	keyRefreshInterval     envVar
+	jwksSnapshotDir        envVar
+	jwksMaxStaleness       envVar
+	jwksRetiredKeyGrace    envVar
+	hmacSecrets            envVar
+	hmacSecretFilePaths    envVar
+	hmacIssuer             envVar
+	decryptionKeyFilePath  envVar
+	introspectionURL       envVar
+	introspectionClientID  envVar
+	introspectionSecret    envVar
+	dpopEnabled            envVar
+	dpopProofMaxAge        envVar
+	certificateBinding     envVar
+	revocationFilePath     envVar
+	revocationPollInterval envVar
+	httpClientTimeout      envVar
+	httpClientCAFilePath   envVar
+	httpClientProxyURL     envVar
+	httpClientCertFilePath envVar
+	httpClientKeyFilePath  envVar
+	httpClientAuthHeader   envVar
+	issuersFilePath        envVar
+	oidcIssuerURL          envVar
+	oidcRefreshInterval    envVar
+	forceJwksOnStart       envVar
+	claimMappingFilePath   envVar
+	authHeader             envVar
+	tokenValidatedHeader   envVar
+	authHeaderRequired     envVar
+	tokenCookies           envVar
+	tokenSources           envVar
+	wsProtocolHeader       envVar
+	port                   envVar
+	logLevel               envVar
+	logType                envVar
+	maxCacheKeys           envVar
+	cacheEnabled           envVar
+	claimMappings          envVar
+	allowedIssuers         envVar
+	allowedIssuersFilePath envVar
+	expectedAudiences      envVar
+	audienceMatch          envVar
+	leeway                 envVar
+	allowedAlgorithms      envVar
+	requiredClaims         envVar
+	maxTokenLifetime       envVar
+	maxTokenAge            envVar
+	maxAuthAge             envVar
+	accessTokenProfile     envVar
+	accessTokenType        envVar
+	keyCost                int64
+	httpClient             *http.Client
 }
 
 func (c *Config) PingHandler(rw http.ResponseWriter, r *http.Request) {
@@ -95,6 +246,7 @@ func (c *Config) RunServer() (chan error, net.Listener) {
 	logger := c.getLogger()
 	log.Logger = logger
 	registry := prom.NewRegistry()
+	decoder.RegisterMetrics(registry)
 	server := c.getServer(registry)
 	var handler http.HandlerFunc = server.DecodeToken
 	var pingHandler http.HandlerFunc = c.PingHandler
@@ -121,9 +273,209 @@ func (c *Config) RunServer() (chan error, net.Listener) {
 }
 
 func (c *Config) getServer(r *prom.Registry) *decoder.Server {
-	jwksURL := c.jwksURL.get()
 	claimMappings := c.getClaimMappings()
-	jwsDec, err := decoder.NewJwsDecoder(jwksURL, claimMappings)
+	hmacDec := c.getHmacDecoder(claimMappings)
+	introspectionDec := c.getIntrospectionDecoder(claimMappings)
+	formats := c.getClaimFormats(claimMappings)
+	var dec decoder.TokenDecoder
+	if issuers := c.getIssuers(); len(issuers) > 0 {
+		decoders := make(map[string]decoder.TokenDecoder)
+		for _, iss := range issuers {
+			issuerMappings := claimMappings.merge(iss.ClaimMappings)
+			decoders[iss.Issuer] = c.getJwsDecoder(iss.JwksURL, issuerMappings, decoder.WithIssuers(iss.Issuer),
+				decoder.WithMappedClaimFormats(c.getClaimFormats(issuerMappings)))
+		}
+		dec = decoder.NewIssuerDecoder(decoders)
+	} else if issuerURL := c.oidcIssuerURL.get(); issuerURL != "" {
+		dec = c.getDiscoveryDecoder(issuerURL, claimMappings)
+	} else {
+		dec = c.getKeySourceDecoder(claimMappings, hmacDec != nil || introspectionDec != nil)
+	}
+	if hmacDec != nil {
+		dec = decoder.NewHmacSplitDecoder(hmacDec, dec)
+	}
+	if path := c.decryptionKeyFilePath.get(); path != "" {
+		dec = c.getJweDecoder(path, dec)
+	}
+	if introspectionDec != nil {
+		if dec == nil {
+			dec = introspectionDec
+		} else {
+			dec = decoder.NewOpaqueSplitDecoder(dec, introspectionDec)
+		}
+	}
+	if c.cacheEnabled.getBool() {
+		dec = decoder.NewCachedJwtDecoder(c.getCache(r), dec)
+	}
+	if path := c.revocationFilePath.get(); path != "" {
+		revocationDec, err := decoder.NewRevocationDecoder(path, c.revocationPollInterval.getDuration(), dec)
+		if err != nil {
+			panic(err)
+		}
+		dec = revocationDec
+	}
+	opts := []decoder.ServerOption{decoder.WithValidation(c.getValidation())}
+	if c.dpopEnabled.getBool() {
+		maxAge := c.dpopProofMaxAge.getDuration()
+		if maxAge <= 0 {
+			panic(fmt.Errorf("%s has to be positive, was %s", DPoPProofMaxAgeEnv, maxAge))
+		}
+		opts = append(opts, decoder.WithDPoP(maxAge))
+	}
+	if c.certificateBinding.getBool() {
+		opts = append(opts, decoder.WithCertificateBinding())
+	}
+	if len(formats) > 0 {
+		opts = append(opts, decoder.WithClaimFormats(formats))
+	}
+	if cookies := c.tokenCookies.getList(); len(cookies) > 0 {
+		log.Info().Strs("cookies", cookies).Msg("reading tokens from cookies without auth header")
+		opts = append(opts, decoder.WithCookies(cookies...))
+	}
+	if sources := c.tokenSources.getList(); len(sources) > 0 {
+		log.Info().Strs("sources", sources).Msg("reading tokens from token sources")
+		opts = append(opts, decoder.WithTokenSources(c.getTokenSources(sources)...))
+	}
+	return decoder.NewServer(dec, c.authHeader.get(), c.tokenValidatedHeader.get(), c.authHeaderRequired.getBool(), opts...)
+}
+
+func (c *Config) getJwsDecoder(jwksURL string, claimMappings claimMappingsT, opts ...decoder.JwsDecoderOption) decoder.TokenDecoder {
+	opts = append(append(c.getJwsDecoderOptions(claimMappings), c.getKeyRefreshOptions()...), opts...)
+	jwsDec, err := decoder.NewJwsDecoder(jwksURL, claimMappings.headers(), opts...)
+	c.handleStartupError(err)
+	logClaimMappings(claimMappings, "jwks", jwksURL)
+	return jwsDec
+}
+
+// getKeySourceDecoder returns a decoder with all configured key sources,
+// if there are none it returns nil when optional and panics otherwise
+func (c *Config) getKeySourceDecoder(claimMappings claimMappingsT, optional bool) decoder.TokenDecoder {
+	var sources []decoder.KeySource
+	var names []string
+	if jwksURL := c.jwksURL.get(); jwksURL != "" {
+		source, err := decoder.NewRemoteKeySource(jwksURL, c.getRemoteKeySourceOptions()...)
+		c.handleStartupError(err)
+		sources, names = append(sources, source), append(names, jwksURL)
+	}
+	if path := c.jwksFilePath.get(); path != "" {
+		sources, names = append(sources, c.getFileKeySource(path, false)), append(names, path)
+	}
+	for _, path := range c.pemKeyFilePaths.getList() {
+		sources, names = append(sources, c.getFileKeySource(path, true)), append(names, path)
+	}
+	if jwks := c.jwks.get(); jwks != "" {
+		keys, err := decoder.ParseKeys([]byte(jwks), false)
+		if err != nil {
+			panic(fmt.Errorf("unable to parse %s: %w", JwksEnv, err))
+		}
+		sources, names = append(sources, decoder.NewStaticKeySource(keys)), append(names, JwksEnv)
+	}
+	if len(sources) == 0 {
+		if optional {
+			return nil
+		}
+		panic(fmt.Errorf("required key %s (or %s, %s, %s, %s) not found in env", JwksURLEnv, JwksFileEnv, JwksEnv, PemKeyFilesEnv, HmacSecretsEnv))
+	}
+	opts := c.getJwsDecoderOptions(claimMappings)
+	if c.jwksURL.get() != "" {
+		opts = append(opts, c.getKeyRefreshOptions()...)
+	}
+	dec := decoder.NewKeySourceDecoder(decoder.NewMultiKeySource(sources...), claimMappings.headers(), opts...)
+	logClaimMappings(claimMappings, "keys", strings.Join(names, ","))
+	return dec
+}
+
+// getHmacDecoder returns a decoder for the HMAC secrets or nil if there are none
+func (c *Config) getHmacDecoder(claimMappings claimMappingsT) decoder.TokenDecoder {
+	secrets := make(map[string][]byte)
+	// one kid:secret per line, the secret is used as is so it may contain any character but line breaks
+	for _, line := range strings.Split(c.hmacSecrets.get(), "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) == "" {
+			continue
+		}
+		ind := strings.Index(line, ":")
+		if ind == -1 {
+			panic(fmt.Errorf("hmac secret has to be on the format kid:secret in %s", HmacSecretsEnv))
+		}
+		secrets[strings.TrimSpace(line[:ind])] = []byte(line[ind+1:])
+	}
+	for _, path := range c.hmacSecretFilePaths.getList() {
+		secret, err := os.ReadFile(path)
+		if err != nil {
+			panic(fmt.Errorf("unable to read hmac secret: %w", err))
+		}
+		secrets[filepath.Base(path)] = bytes.TrimRight(secret, "\r\n")
+	}
+	if len(secrets) == 0 {
+		return nil
+	}
+	if algs := c.allowedAlgorithms.getList(); len(algs) > 0 && !containsHmac(signatureAlgorithms(algs)) {
+		panic(fmt.Errorf("%s allows none of HS256, HS384 and HS512 but %s or %s is set", AllowedAlgorithmsEnv, HmacSecretsEnv, HmacSecretFilesEnv))
+	}
+	keys, err := decoder.NewHmacKeySource(secrets)
+	if err != nil {
+		panic(err)
+	}
+	opts := c.getJwsDecoderOptions(claimMappings)
+	if iss := c.hmacIssuer.get(); iss != "" {
+		opts = append(opts, decoder.WithIssuers(iss))
+	}
+	kids := make([]string, 0, len(secrets))
+	for kid := range secrets {
+		kids = append(kids, kid)
+	}
+	logClaimMappings(claimMappings, "hmac", strings.Join(kids, ","))
+	return decoder.NewHmacDecoder(keys, claimMappings.headers(), opts...)
+}
+
+// getIntrospectionDecoder returns a decoder for opaque tokens or nil if there is no introspection endpoint
+func (c *Config) getIntrospectionDecoder(claimMappings claimMappingsT) decoder.TokenDecoder {
+	endpoint := c.introspectionURL.get()
+	if endpoint == "" {
+		return nil
+	}
+	logClaimMappings(claimMappings, "introspection", endpoint)
+	return decoder.NewIntrospectionDecoder(endpoint, c.introspectionClientID.get(), c.introspectionSecret.get(), claimMappings.headers(), c.getHTTPClient(),
+		c.getClaimPolicy(claimMappings))
+}
+
+func (c *Config) getJweDecoder(path string, dec decoder.TokenDecoder) decoder.TokenDecoder {
+	if dec == nil {
+		panic(fmt.Errorf("%s requires a key source to verify the decrypted tokens", DecryptionKeyFileEnv))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(fmt.Errorf("unable to read decryption key: %w", err))
+	}
+	key, err := decoder.ParseDecryptionKey(data)
+	if err != nil {
+		panic(err)
+	}
+	jweDec, err := decoder.NewJweDecoder(key, dec)
+	if err != nil {
+		panic(err)
+	}
+	log.Info().Str("key", path).Msg("decrypting encrypted tokens")
+	return jweDec
+}
+
+func (c *Config) getFileKeySource(path string, pem bool) decoder.KeySource {
+	source, err := decoder.NewFileKeySource(path, pem, c.keyFilePollInterval.getDuration())
+	if err != nil {
+		panic(fmt.Errorf("unable to load keys from %s: %w", path, err))
+	}
+	return source
+}
+
+func (c *Config) getDiscoveryDecoder(issuerURL string, claimMappings claimMappingsT) decoder.TokenDecoder {
+	dec, err := decoder.NewDiscoveryDecoder(issuerURL, claimMappings.headers(), c.oidcRefreshInterval.getDuration(), c.getHTTPClient(),
+		c.getRemoteKeySourceOptions(), append(c.getJwsDecoderOptions(claimMappings), c.getKeyRefreshOptions()...)...)
+	c.handleStartupError(err)
+	logClaimMappings(claimMappings, "issuer", issuerURL)
+	return dec
+}
+
+func (c *Config) handleStartupError(err error) {
 	if err != nil {
 		if c.forceJwksOnStart.getBool() {
 			panic(err)
@@ -131,18 +483,23 @@ func (c *Config) getServer(r *prom.Registry) *decoder.Server {
 			log.Warn().Err(err).Msg("will try again")
 		}
 	}
+}
+
+func logClaimMappings(claimMappings claimMappingsT, sourceKey, source string) {
 	claimMsg := zerolog.Dict()
-	for k, v := range claimMappings {
+	for k, v := range claimMappings.headers() {
 		claimMsg.Str(k, v)
 	}
-	log.Info().Dict("mappings", claimMsg).Msg("mappings from claim keys to header")
-	var dec decoder.TokenDecoder
-	if c.cacheEnabled.getBool() {
-		dec = decoder.NewCachedJwtDecoder(c.getCache(r), jwsDec)
-	} else {
-		dec = jwsDec
+	log.Info().Str(sourceKey, source).Dict("mappings", claimMsg).Msg("mappings from claim keys to header")
+}
+
+func (c *Config) getValidation() decoder.Validation {
+	return decoder.Validation{
+		Leeway:      c.leeway.getDuration(),
+		MaxLifetime: c.maxTokenLifetime.getDuration(),
+		MaxAge:      c.maxTokenAge.getDuration(),
+		MaxAuthAge:  c.maxAuthAge.getDuration(),
 	}
-	return decoder.NewServer(dec, c.authHeader.get(), c.tokenValidatedHeader.get(), c.authHeaderRequired.getBool())
 }
 
 func (c *Config) getLogger() (logger zerolog.Logger) {
@@ -180,8 +537,8 @@ func (c *Config) getCache(r *prom.Registry) *ristretto.Cache {
 	return cache
 }
 
-func (c *Config) getClaimMappings() map[string]string {
-	var claimMappings claimMappingsT = make(map[string]string)
+func (c *Config) getClaimMappings() claimMappingsT {
+	claimMappings := make(claimMappingsT)
 	path := c.claimMappingFilePath.get()
 	errFile := claimMappings.fromFile(path)
 	if errFile != nil {
@@ -197,32 +554,163 @@ func (c *Config) getClaimMappings() map[string]string {
 	return claimMappings
 }
 
-type claimMappingsT map[string]string
-
-func (c claimMappingsT) fromFile(path string) error {
-	claimMappingFile, err := os.Open(path)
+func (c *Config) getClaimFormats(claimMappings claimMappingsT) map[string]decoder.ClaimFormat {
+	formats, err := claimMappings.formats()
 	if err != nil {
-		return err
+		panic(err)
 	}
-	defer claimMappingFile.Close()
-	return json.NewDecoder(claimMappingFile).Decode(&c)
+	return formats
 }
 
-func (c claimMappingsT) fromString(val string) error {
-	mappings := strings.Split(val, ",")
-	for _, mapping := range mappings {
-		if len(mapping) == 0 {
-			continue
+func (c *Config) getJwsDecoderOptions(claimMappings claimMappingsT) []decoder.JwsDecoderOption {
+	opts := []decoder.JwsDecoderOption{decoder.WithClaimPolicy(c.getClaimPolicy(claimMappings))}
+	if algs := c.allowedAlgorithms.getList(); len(algs) > 0 {
+		log.Info().Strs("algorithms", algs).Msg("only accepting tokens signed with allowed algorithms")
+		opts = append(opts, decoder.WithAlgorithms(signatureAlgorithms(algs)...))
+	}
+	if c.accessTokenProfile.getBool() {
+		log.Info().Str("typ", c.accessTokenType.get()).Msg("only accepting JWT access tokens")
+		opts = append(opts, decoder.WithAccessTokenProfile(c.accessTokenType.get()))
+	}
+	return append(opts, decoder.WithRemoteKeySourceOptions(c.getRemoteKeySourceOptions()...))
+}
+
+// getKeyRefreshOptions returns the option to refetch the keys for unknown kids, only for decoders with a JWKS url
+func (c *Config) getKeyRefreshOptions() []decoder.JwsDecoderOption {
+	if interval := c.keyRefreshInterval.getDuration(); interval > 0 {
+		return []decoder.JwsDecoderOption{decoder.WithKeyRefresh(interval)}
+	}
+	return nil
+}
+
+// getClaimPolicy returns the required claims, allowed issuers and expected audiences
+// which apply to JWTs and introspected opaque tokens
+func (c *Config) getClaimPolicy(claimMappings claimMappingsT) decoder.ClaimPolicy {
+	var policy decoder.ClaimPolicy
+	if required := append(claimMappings.required(), c.requiredClaims.getList()...); len(required) > 0 {
+		log.Info().Strs("claims", required).Msg("only accepting tokens with required claims")
+		policy.RequiredClaims = required
+	}
+	if issuers := c.getAllowedIssuers(); len(issuers) > 0 {
+		log.Info().Strs("issuers", issuers).Msg("only accepting tokens from allowed issuers")
+		policy.Issuers = issuers
+	}
+	if audiences := c.expectedAudiences.getList(); len(audiences) > 0 {
+		match := decoder.AudienceMatch(c.audienceMatch.get())
+		if match != decoder.AnyAudience && match != decoder.AllAudiences {
+			panic(fmt.Errorf("unknown audience match %s", match))
 		}
-		lastInd := strings.LastIndex(mapping, ":")
-		if lastInd == -1 {
-			return fmt.Errorf("unexpected number of ':' in claim mapping '%s'", mapping)
+		log.Info().Strs("audiences", audiences).Str("match", string(match)).Msg("only accepting tokens for expected audiences")
+		policy.Audiences, policy.AudienceMatch = audiences, match
+	}
+	return policy
+}
+
+func (c *Config) getRemoteKeySourceOptions() []decoder.RemoteKeySourceOption {
+	opts := []decoder.RemoteKeySourceOption{decoder.WithHTTPClient(c.getHTTPClient())}
+	if dir := c.jwksSnapshotDir.get(); dir != "" {
+		opts = append(opts, decoder.WithSnapshotDir(dir))
+	}
+	if maxStaleness := c.jwksMaxStaleness.getDuration(); maxStaleness > 0 {
+		opts = append(opts, decoder.WithMaxStaleness(maxStaleness))
+	}
+	if gracePeriod := c.jwksRetiredKeyGrace.getDuration(); gracePeriod > 0 {
+		opts = append(opts, decoder.WithRetiredKeyGracePeriod(gracePeriod))
+	}
+	return opts
+}
+
+// issuer configures the JWKS and additional claim mappings of one of multiple issuers
+type issuer struct {
+	Issuer        string         `json:"issuer"`
+	JwksURL       string         `json:"jwksUrl"`
+	ClaimMappings claimMappingsT `json:"claimMappings"`
+}
+
+func (c *Config) getIssuers() []issuer {
+	path := c.issuersFilePath.get()
+	if path == "" {
+		return nil
+	}
+	var issuers []issuer
+	if err := readJSONFile(path, &issuers); err != nil {
+		panic(fmt.Errorf("unable to load issuers file: %w", err))
+	}
+	for _, iss := range issuers {
+		if iss.Issuer == "" || iss.JwksURL == "" {
+			panic(fmt.Errorf("issuer and jwksUrl are required for all issuers in %s", path))
 		}
-		key := mapping[:lastInd]
-		value := mapping[lastInd+1:]
-		c[key] = value
 	}
-	return nil
+	return issuers
+}
+
+func (c *Config) getAllowedIssuers() []string {
+	issuers := c.allowedIssuers.getList()
+	if path := c.allowedIssuersFilePath.get(); path != "" {
+		var fromFile []string
+		if err := readJSONFile(path, &fromFile); err != nil {
+			panic(fmt.Errorf("unable to load allowed issuers file: %w", err))
+		}
+		issuers = append(issuers, fromFile...)
+	}
+	return issuers
+}
+
+// getTokenSources parses the token sources on the format header:<name>[:<scheme>|<scheme>], cookie:<name>,
+// query:<param> or websocket:<subprotocol>
+func (c *Config) getTokenSources(specs []string) []decoder.TokenSource {
+	sources := make([]decoder.TokenSource, len(specs))
+	for i, spec := range specs {
+		parts := strings.SplitN(spec, ":", 3)
+		if len(parts) < 2 || parts[1] == "" {
+			panic(fmt.Errorf("token source %s has to be on the format type:name in %s", spec, TokenSourcesEnv))
+		}
+		switch parts[0] {
+		case "header":
+			var schemes []string
+			if len(parts) == 3 {
+				schemes = strings.Split(parts[2], "|")
+			}
+			sources[i] = decoder.HeaderSource(parts[1], schemes...)
+		case "cookie":
+			sources[i] = decoder.CookieSource(parts[1])
+		case "query":
+			sources[i] = decoder.QuerySource(parts[1])
+		case "websocket":
+			sources[i] = decoder.WebSocketProtocolSource(parts[1], c.wsProtocolHeader.get())
+		default:
+			panic(fmt.Errorf("unknown token source type %s in %s", parts[0], TokenSourcesEnv))
+		}
+	}
+	return sources
+}
+
+func signatureAlgorithms(names []string) []jwa.SignatureAlgorithm {
+	algs := make([]jwa.SignatureAlgorithm, len(names))
+	for i, name := range names {
+		if err := algs[i].Accept(name); err != nil {
+			panic(fmt.Errorf("unknown signing algorithm %s: %w", name, err))
+		}
+	}
+	return algs
+}
+
+func containsHmac(algs []jwa.SignatureAlgorithm) bool {
+	for _, alg := range algs {
+		if alg == jwa.HS256 || alg == jwa.HS384 || alg == jwa.HS512 {
+			return true
+		}
+	}
+	return false
+}
+
+func readJSONFile(path string, v interface{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewDecoder(file).Decode(v)
 }
 
 func histogramMiddleware(r *prom.Registry) func(handler http.Handler) http.Handler {
@@ -304,6 +792,23 @@ func (e envVar) getInt64() (val int64) {
 	return
 }
 
+func (e envVar) getDuration() time.Duration {
+	val, err := time.ParseDuration(e.get())
+	if err != nil {
+		panic(fmt.Errorf("%s has to be a duration: %w", e.name, err))
+	}
+	return val
+}
+
+func (e envVar) getList() (vals []string) {
+	for _, val := range strings.Split(e.get(), ",") {
+		if val = strings.TrimSpace(val); val != "" {
+			vals = append(vals, val)
+		}
+	}
+	return
+}
+
 func (e envVar) getBool() (val bool) {
 	str := e.get()
 	switch str {
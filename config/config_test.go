@@ -1,18 +1,26 @@
 package config_test
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	c "github.com/SimonSchneider/traefik-jwt-decode/config"
 
 	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+	"github.com/lestrrat-go/jwx/jwa"
 )
 
 var (
@@ -37,6 +45,28 @@ func TestEnvClaimMappingsConfiguration(t *testing.T) {
 	validateCorrectSetup(t, tc, c.AuthHeaderDefault)
 }
 
+func TestInlineJwks(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.JwksURLEnv, "")
+	os.Setenv(c.JwksEnv, string(tc.JWKS()))
+	validateCorrectSetup(t, tc, c.AuthHeaderDefault)
+}
+
+func TestPemKeyFiles(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	file, err := ioutil.TempFile(".", "key.pem")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	file.Write(tc.PublicKeyPEM())
+	os.Setenv(c.JwksURLEnv, "")
+	os.Setenv(c.PemKeyFilesEnv, file.Name())
+	validateCorrectSetup(t, tc, c.AuthHeaderDefault)
+}
+
 func TestChangingPort(t *testing.T) {
 	os.Clearenv()
 	tc := dt.NewTest()
@@ -66,6 +96,80 @@ func TestClaimMappingFile(t *testing.T) {
 	validateCorrectSetup(t, tc, c.AuthHeaderDefault)
 }
 
+func TestNestedClaimMappings(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	file, err := ioutil.TempFile(".", "config.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	json.NewEncoder(file).Encode(map[string]string{"/resource_access/app/roles": "app-roles"})
+	os.Setenv(c.ClaimMappingsEnv, "realm_access.roles.0:realm-role")
+	os.Setenv(c.ClaimMappingFileEnv, file.Name())
+	conf := c.NewConfig()
+	_, l := conf.RunServer()
+	defer l.Close()
+	token := tc.NewValidToken(map[string]interface{}{
+		"realm_access":    map[string]interface{}{"roles": []string{"admin"}},
+		"resource_access": map[string]interface{}{"app": map[string]interface{}{"roles": []string{"reader", "writer"}}},
+	})
+	req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d", l.Addr().(*net.TCPAddr).Port), nil)
+	req.Header.Set(c.AuthHeaderDefault, fmt.Sprintf("Bearer %s", token))
+	resp, err := http.DefaultClient.Do(req)
+	dt.HandleByPanic(err)
+	dt.Report(t, resp.Header.Get("realm-role") != "admin", "incorrect header for realm_access.roles.0 '%s'", resp.Header.Get("realm-role"))
+	dt.Report(t, resp.Header.Get("app-roles") != `["reader","writer"]`, "incorrect header for /resource_access/app/roles '%s'", resp.Header.Get("app-roles"))
+}
+
+func TestClaimFormats(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	file, err := ioutil.TempFile(".", "config.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	file.WriteString(`{
+		"groups": { "header": "jwt-token-groups", "array": "repeat" },
+		"realm_access.roles": { "header": "jwt-token-roles", "array": "join", "delimiter": " " },
+		"attrs": { "header": "jwt-token-attrs", "object": "flatten" },
+		"scopes": "jwt-token-scopes"
+	}`)
+	file.Close()
+	os.Setenv(c.ClaimMappingsEnv, "")
+	os.Setenv(c.ClaimMappingFileEnv, file.Name())
+	conf := c.NewConfig()
+	_, l := conf.RunServer()
+	defer l.Close()
+	token := tc.NewValidToken(map[string]interface{}{
+		"groups":       []string{"admin", "dev"},
+		"realm_access": map[string]interface{}{"roles": []string{"reader", "writer"}},
+		"attrs":        map[string]interface{}{"team": "core", "region": "eu"},
+		"scopes":       []string{"read"},
+	})
+	req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d", l.Addr().(*net.TCPAddr).Port), nil)
+	req.Header.Set(c.AuthHeaderDefault, fmt.Sprintf("Bearer %s", token))
+	resp, err := http.DefaultClient.Do(req)
+	dt.HandleByPanic(err)
+	groups := resp.Header.Values("jwt-token-groups")
+	dt.Report(t, len(groups) != 2 || groups[0] != "admin" || groups[1] != "dev", "incorrect repeated groups headers %v", groups)
+	dt.Report(t, resp.Header.Get("jwt-token-roles") != "reader writer", "incorrect joined roles header '%s'", resp.Header.Get("jwt-token-roles"))
+	dt.Report(t, resp.Header.Get("jwt-token-attrs") != "region=eu,team=core", "incorrect flattened attrs header '%s'", resp.Header.Get("jwt-token-attrs"))
+	dt.Report(t, resp.Header.Get("jwt-token-scopes") != `["read"]`, "incorrect json scopes header '%s'", resp.Header.Get("jwt-token-scopes"))
+}
+
+func TestFailsOnUnknownClaimFormat(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	file, err := ioutil.TempFile(".", "config.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	file.WriteString(`{ "groups": { "header": "jwt-token-groups", "array": "csv" } }`)
+	file.Close()
+	os.Setenv(c.ClaimMappingFileEnv, file.Name())
+	validatePanicsWhenStarting(t)
+}
+
 func TestMergeClaimMappingsFileAndEnv(t *testing.T) {
 	os.Clearenv()
 	tc := dt.NewTest()
@@ -79,6 +183,424 @@ func TestMergeClaimMappingsFileAndEnv(t *testing.T) {
 	validateCorrectSetup(t, tc, c.AuthHeaderDefault)
 }
 
+func TestAllowedIssuers(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	file, err := ioutil.TempFile(".", "issuers.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	json.NewEncoder(file).Encode([]string{"https://issuer.two"})
+	os.Setenv(c.AllowedIssuersEnv, "https://issuer.one")
+	os.Setenv(c.AllowedIssuersFileEnv, file.Name())
+	validateStatus(t, map[string]int{
+		string(tc.NewValidToken(map[string]interface{}{"iss": "https://issuer.one"})):   http.StatusOK,
+		string(tc.NewValidToken(map[string]interface{}{"iss": "https://issuer.two"})):   http.StatusOK,
+		string(tc.NewValidToken(map[string]interface{}{"iss": "https://issuer.three"})): http.StatusUnauthorized,
+	})
+}
+
+func TestRequiredClaims(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	file, err := ioutil.TempFile(".", "config.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	file.WriteString(`{"claim1": {"header": "claimHeader1", "required": true}, "claim2": "claimHeader2"}`)
+	os.Setenv(c.ClaimMappingsEnv, "")
+	os.Setenv(c.ClaimMappingFileEnv, file.Name())
+	os.Setenv(c.RequiredClaimsEnv, "claim:3")
+	validateStatus(t, map[string]int{
+		string(tc.NewValidToken(claims)):                                                http.StatusOK,
+		string(tc.NewValidToken(map[string]interface{}{"claim1": "1", "claim2": "2"})):  http.StatusUnauthorized,
+		string(tc.NewValidToken(map[string]interface{}{"claim2": "2", "claim:3": "3"})): http.StatusUnauthorized,
+	})
+}
+
+func TestMultipleIssuers(t *testing.T) {
+	os.Clearenv()
+	staff, customers := dt.NewTest(), dt.NewTest()
+	defaultEnv(staff)
+	os.Setenv(c.JwksURLEnv, "")
+	file, err := ioutil.TempFile(".", "issuers.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	fmt.Fprintf(file, `[
+		{"issuer": "https://staff", "jwksUrl": "%s"},
+		{"issuer": "https://customers", "jwksUrl": "%s", "claimMappings": {"email": {"header": "jwt-token-email", "required": true}}}
+	]`, staff.JwksURL, customers.JwksURL)
+	os.Setenv(c.IssuersFileEnv, file.Name())
+	validateStatus(t, map[string]int{
+		string(staff.NewValidToken(map[string]interface{}{"iss": "https://staff"})):                         http.StatusOK,
+		string(customers.NewValidToken(map[string]interface{}{"iss": "https://customers", "email": "a@b"})): http.StatusOK,
+		string(customers.NewValidToken(map[string]interface{}{"iss": "https://customers"})):                 http.StatusUnauthorized,
+		string(customers.NewValidToken(map[string]interface{}{"iss": "https://staff"})):                     http.StatusUnauthorized,
+		string(staff.NewValidToken(map[string]interface{}{"iss": "https://other"})):                         http.StatusUnauthorized,
+	})
+}
+
+func TestIssuerClaimFormats(t *testing.T) {
+	os.Clearenv()
+	staff, customers, partners := dt.NewTest(), dt.NewTest(), dt.NewTest()
+	defaultEnv(staff)
+	os.Setenv(c.JwksURLEnv, "")
+	mappingFile, err := ioutil.TempFile(".", "config.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(mappingFile.Name())
+	mappingFile.WriteString(`{ "groups": { "header": "jwt-token-groups", "array": "join" } }`)
+	mappingFile.Close()
+	os.Setenv(c.ClaimMappingsEnv, "")
+	os.Setenv(c.ClaimMappingFileEnv, mappingFile.Name())
+	file, err := ioutil.TempFile(".", "issuers.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	fmt.Fprintf(file, `[
+		{"issuer": "https://staff", "jwksUrl": "%s"},
+		{"issuer": "https://customers", "jwksUrl": "%s", "claimMappings": {"groups": {"header": "jwt-token-groups", "array": "repeat"}}},
+		{"issuer": "https://partners", "jwksUrl": "%s", "claimMappings": {"groups": "jwt-token-groups"}}
+	]`, staff.JwksURL, customers.JwksURL, partners.JwksURL)
+	file.Close()
+	os.Setenv(c.IssuersFileEnv, file.Name())
+	conf := c.NewConfig()
+	_, l := conf.RunServer()
+	defer l.Close()
+	groups := map[string]interface{}{"groups": []string{"admin", "dev"}}
+	tests := map[string]struct {
+		tc       *dt.TestConfig
+		expected []string
+	}{
+		"https://staff":     {tc: staff, expected: []string{"admin,dev"}},
+		"https://customers": {tc: customers, expected: []string{"admin", "dev"}},
+		"https://partners":  {tc: partners, expected: []string{`["admin","dev"]`}},
+	}
+	for iss, test := range tests {
+		groups["iss"] = iss
+		req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d", l.Addr().(*net.TCPAddr).Port), nil)
+		req.Header.Set(c.AuthHeaderDefault, fmt.Sprintf("Bearer %s", test.tc.NewValidToken(groups)))
+		resp, err := http.DefaultClient.Do(req)
+		dt.HandleByPanic(err)
+		values := resp.Header.Values("jwt-token-groups")
+		dt.Report(t, strings.Join(values, "|") != strings.Join(test.expected, "|"), "groups headers of %s are %q, expected: %q", iss, values, test.expected)
+	}
+}
+
+func TestOidcDiscovery(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.JwksURLEnv, "")
+	os.Setenv(c.OidcIssuerURLEnv, tc.IssuerURL)
+	validateStatus(t, map[string]int{
+		string(tc.NewValidToken(map[string]interface{}{"iss": tc.IssuerURL})):    http.StatusOK,
+		string(tc.NewValidToken(map[string]interface{}{"iss": "https://other"})): http.StatusUnauthorized,
+	})
+}
+
+func TestFailsOnBadOidcIssuer(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.OidcIssuerURLEnv, "http://non-existing")
+	os.Setenv(c.ForceJwksOnStart, "true")
+	validatePanicsWhenStarting(t)
+}
+
+func TestHmacSecrets(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	file, err := ioutil.TempFile(".", "new-kid")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	newSecret := strings.Repeat("new-secret", 5)
+	file.WriteString(newSecret + "\n")
+	oldSecret, otherSecret := "old:secret, with separators, and spaces ", "other-secret-other-secret-other-secret"
+	os.Setenv(c.HmacSecretsEnv, "old-kid:"+oldSecret+"\nother-kid:"+otherSecret)
+	os.Setenv(c.HmacSecretFilesEnv, file.Name())
+	os.Setenv(c.HmacIssuerEnv, "batch")
+	batch := map[string]interface{}{"iss": "batch"}
+	validateStatus(t, map[string]int{
+		string(tc.NewValidToken(map[string]interface{}{})):                                         http.StatusOK,
+		string(dt.NewHmacToken(batch, jwa.HS256, "old-kid", []byte(oldSecret))):                    http.StatusOK,
+		string(dt.NewHmacToken(batch, jwa.HS256, "other-kid", []byte(otherSecret))):                http.StatusOK,
+		string(dt.NewHmacToken(batch, jwa.HS384, filepath.Base(file.Name()), []byte(newSecret))):   http.StatusOK,
+		string(dt.NewHmacToken(batch, jwa.HS512, filepath.Base(file.Name()), []byte(newSecret))):   http.StatusUnauthorized,
+		string(dt.NewHmacToken(batch, jwa.HS256, "old-kid", []byte(newSecret))):                    http.StatusUnauthorized,
+		string(dt.NewHmacToken(map[string]interface{}{}, jwa.HS256, "old-kid", []byte(oldSecret))): http.StatusUnauthorized,
+	})
+}
+
+func TestShortHmacSecret(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.HmacSecretsEnv, "kid:short-secret")
+	validatePanicsWhenStarting(t)
+}
+
+func TestHmacSecretsWithoutHmacAlgorithm(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.HmacSecretsEnv, "kid:"+strings.Repeat("secret", 6))
+	os.Setenv(c.AllowedAlgorithmsEnv, "RS256")
+	validatePanicsWhenStarting(t)
+}
+
+func TestJweDecryptionKey(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	file, err := ioutil.TempFile(".", "decryption-key")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	pem.Encode(file, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	os.Setenv(c.DecryptionKeyFileEnv, file.Name())
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	validateStatus(t, map[string]int{
+		string(tc.NewValidToken(map[string]interface{}{})):                                                 http.StatusOK,
+		string(dt.Encrypt(tc.NewValidToken(map[string]interface{}{}), jwa.RSA_OAEP_256, &key.PublicKey)):   http.StatusOK,
+		string(dt.Encrypt(tc.NewInvalidToken(map[string]interface{}{}), jwa.RSA_OAEP_256, &key.PublicKey)): http.StatusUnauthorized,
+		string(dt.Encrypt(tc.NewValidToken(map[string]interface{}{}), jwa.RSA_OAEP_256, &other.PublicKey)): http.StatusUnauthorized,
+	})
+}
+
+func TestRevocationList(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	file, err := ioutil.TempFile(".", "revoked.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	file.WriteString(fmt.Sprintf(`[{ "jti": "leaked", "exp": %d }, { "sub": "revoked-user" }]`, time.Now().Add(time.Hour).Unix()))
+	os.Setenv(c.RevocationFileEnv, file.Name())
+	validateStatus(t, map[string]int{
+		string(tc.NewValidToken(map[string]interface{}{"jti": "valid"})):        http.StatusOK,
+		string(tc.NewValidToken(map[string]interface{}{"jti": "leaked"})):       http.StatusUnauthorized,
+		string(tc.NewValidToken(map[string]interface{}{"sub": "revoked-user"})): http.StatusUnauthorized,
+	})
+}
+
+func TestIntrospection(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		active := id == "client" && secret == "secret" && r.PostFormValue("token") == "opaque"
+		json.NewEncoder(rw).Encode(map[string]interface{}{"active": active, "exp": time.Now().Add(time.Hour).Unix()})
+	}))
+	defer server.Close()
+	os.Setenv(c.IntrospectionURLEnv, server.URL)
+	os.Setenv(c.IntrospectionClientIDEnv, "client")
+	os.Setenv(c.IntrospectionSecretEnv, "secret")
+	validateStatus(t, map[string]int{
+		string(tc.NewValidToken(map[string]interface{}{})): http.StatusOK,
+		"opaque":  http.StatusOK,
+		"unknown": http.StatusUnauthorized,
+	})
+}
+
+func TestDPoP(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.DPoPEnabledEnv, "true")
+	key := dt.NewDPoPKey()
+	token := tc.NewValidToken(map[string]interface{}{"cnf": map[string]string{"jkt": key.Thumbprint()}})
+	dpopRequest := func(proof []byte) http.Header {
+		return http.Header{
+			c.AuthHeaderDefault:  {fmt.Sprintf("DPoP %s", token)},
+			"DPoP":               {string(proof)},
+			"X-Forwarded-Method": {"GET"},
+			"X-Forwarded-Proto":  {"https"},
+			"X-Forwarded-Host":   {"api.example.com"},
+			"X-Forwarded-Uri":    {"/orders"},
+		}
+	}
+	proof := key.NewProof(dt.ProofClaims("GET", "https://api.example.com/orders", token))
+	validateRequests(t, []statusRequest{
+		{headers: dpopRequest(proof), status: http.StatusOK},
+		{headers: dpopRequest(proof), status: http.StatusUnauthorized},
+		{headers: dpopRequest(key.NewProof(dt.ProofClaims("POST", "https://api.example.com/orders", token))), status: http.StatusUnauthorized},
+		{headers: http.Header{c.AuthHeaderDefault: {fmt.Sprintf("Bearer %s", token)}}, status: http.StatusUnauthorized},
+	})
+}
+
+func TestCertificateBinding(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.CertificateBindingEnv, "true")
+	cert, thumbprint := dt.NewClientCert()
+	otherCert, _ := dt.NewClientCert()
+	token := tc.NewValidToken(map[string]interface{}{"cnf": map[string]string{"x5t#S256": thumbprint}})
+	auth := fmt.Sprintf("Bearer %s", token)
+	validateRequests(t, []statusRequest{
+		{headers: http.Header{c.AuthHeaderDefault: {auth}, "X-Forwarded-Tls-Client-Cert": {cert}}, status: http.StatusOK},
+		{headers: http.Header{c.AuthHeaderDefault: {auth}, "X-Forwarded-Tls-Client-Cert": {otherCert}}, status: http.StatusUnauthorized},
+		{headers: http.Header{c.AuthHeaderDefault: {auth}}, status: http.StatusUnauthorized},
+	})
+}
+
+func TestTokenCookies(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.TokenCookiesEnv, "access_token")
+	token := string(tc.NewValidToken(map[string]interface{}{}))
+	invalid := string(tc.NewInvalidToken(map[string]interface{}{}))
+	validateRequests(t, []statusRequest{
+		{headers: http.Header{"Cookie": {fmt.Sprintf("access_token=%s", token)}}, status: http.StatusOK},
+		{headers: http.Header{"Cookie": {fmt.Sprintf("access_token.0=%s; access_token.1=%s", token[:10], token[10:])}}, status: http.StatusOK},
+		{headers: http.Header{"Cookie": {fmt.Sprintf("access_token=%s", invalid)}}, status: http.StatusUnauthorized},
+		{headers: http.Header{c.AuthHeaderDefault: {fmt.Sprintf("Bearer %s", invalid)}, "Cookie": {fmt.Sprintf("access_token=%s", token)}}, status: http.StatusUnauthorized},
+	})
+}
+
+func TestTokenSources(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.TokenSourcesEnv, "header:Authorization:Bearer,query:access_token,header:Proxy-Authorization:Bearer|Token")
+	token := string(tc.NewValidToken(map[string]interface{}{}))
+	other := string(tc.NewValidToken(map[string]interface{}{"sub": "other"}))
+	validateRequests(t, []statusRequest{
+		{headers: http.Header{c.AuthHeaderDefault: {fmt.Sprintf("bearer %s", token)}}, status: http.StatusOK},
+		{headers: http.Header{c.AuthHeaderDefault: {token}}, status: http.StatusUnauthorized},
+		{headers: http.Header{"X-Forwarded-Uri": {fmt.Sprintf("/?access_token=%s", token)}}, status: http.StatusOK},
+		{headers: http.Header{"Proxy-Authorization": {fmt.Sprintf("Token %s", token)}}, status: http.StatusOK},
+		{headers: http.Header{c.AuthHeaderDefault: {fmt.Sprintf("Bearer %s", token)}, "Proxy-Authorization": {fmt.Sprintf("Bearer %s", other)}}, status: http.StatusUnauthorized},
+	})
+}
+
+func TestWebSocketTokenSource(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.TokenSourcesEnv, "header:Authorization:Bearer,websocket:access_token")
+	conf := c.NewConfig()
+	_, l := conf.RunServer()
+	defer l.Close()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d", l.Addr().(*net.TCPAddr).Port), nil)
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Protocol", fmt.Sprintf("access_token, %s", tc.NewValidToken(map[string]interface{}{})))
+	resp, err := http.DefaultClient.Do(req)
+	dt.HandleByPanic(err)
+	dt.Report(t, resp.StatusCode != http.StatusOK, "incorrect status for websocket token: %d", resp.StatusCode)
+	protocol := resp.Header.Get(c.WebSocketProtocolDefault)
+	dt.Report(t, protocol != "access_token", "incorrect subprotocol to echo '%s'", protocol)
+}
+
+func TestFailsOnUnknownTokenSource(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.TokenSourcesEnv, "form:access_token")
+	validatePanicsWhenStarting(t)
+}
+
+func TestJwksSnapshot(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	dir, err := ioutil.TempDir(".", "snapshots")
+	dt.HandleByPanic(err)
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write(tc.JWKS())
+	}))
+	os.Setenv(c.JwksURLEnv, server.URL)
+	os.Setenv(c.JwksSnapshotDirEnv, dir)
+	os.Setenv(c.JwksMaxStalenessEnv, "1h")
+	token := string(tc.NewValidToken(map[string]interface{}{}))
+	validateStatus(t, map[string]int{token: http.StatusOK})
+	server.Close()
+	validateStatus(t, map[string]int{token: http.StatusOK})
+}
+
+func TestHTTPClient(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fetch-token" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Write(tc.JWKS())
+	}))
+	defer server.Close()
+	file, err := ioutil.TempFile(".", "ca.pem")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	dt.HandleByPanic(pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	file.Close()
+	os.Setenv(c.JwksURLEnv, server.URL)
+	os.Setenv(c.HTTPClientCAFileEnv, file.Name())
+	os.Setenv(c.HTTPClientAuthHeaderEnv, "Bearer fetch-token")
+	os.Setenv(c.ForceJwksOnStart, "true")
+	validateStatus(t, map[string]int{string(tc.NewValidToken(map[string]interface{}{})): http.StatusOK})
+}
+
+func TestHTTPClientAuthHeaderNotSentToOtherHosts(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	var leaked string
+	other := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Authorization")
+		rw.Write(tc.JWKS())
+	}))
+	defer other.Close()
+	configured := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fetch-token" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(rw, r, other.URL, http.StatusFound)
+	}))
+	defer configured.Close()
+	os.Setenv(c.JwksURLEnv, configured.URL)
+	os.Setenv(c.HTTPClientAuthHeaderEnv, "Bearer fetch-token")
+	os.Setenv(c.ForceJwksOnStart, "true")
+	validateStatus(t, map[string]int{string(tc.NewValidToken(map[string]interface{}{})): http.StatusOK})
+	dt.Report(t, leaked != "", "expected no Authorization header after the redirect to another host got %s", leaked)
+}
+
+// validateStatus starts a server and validates the response status for each token
+func validateStatus(t *testing.T, statusByToken map[string]int) {
+	var requests []statusRequest
+	for token, status := range statusByToken {
+		requests = append(requests, statusRequest{headers: http.Header{c.AuthHeaderDefault: {fmt.Sprintf("Bearer %s", token)}}, status: status})
+	}
+	validateRequests(t, requests)
+}
+
+type statusRequest struct {
+	headers http.Header
+	status  int
+}
+
+// validateRequests starts a server and validates the response status for each request in order
+func validateRequests(t *testing.T, requests []statusRequest) {
+	conf := c.NewConfig()
+	doneChan, l := conf.RunServer()
+	port := l.Addr().(*net.TCPAddr).Port
+	for _, request := range requests {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d", port), nil)
+		req.Header = request.headers
+		resp, err := http.DefaultClient.Do(req)
+		dt.HandleByPanic(err)
+		dt.Report(t, resp.StatusCode != request.status, "incorrect status for request %v: %d expected %d", request.headers, resp.StatusCode, request.status)
+	}
+	err := l.Close()
+	dt.HandleByPanic(err)
+	<-doneChan
+}
+
 func validateCorrectSetup(t *testing.T, tc *dt.TestConfig, authKey string) {
 	conf := c.NewConfig()
 	doneChan, l := conf.RunServer()
@@ -157,6 +679,40 @@ func TestWarningOnBadJwksURL(t *testing.T) {
 	validateWarningWhenStarting(t)
 }
 
+func TestFailsOnBadAudienceMatch(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.ExpectedAudiencesEnv, "service-a")
+	os.Setenv(c.AudienceMatchEnv, "some")
+	validatePanicsWhenStarting(t)
+}
+
+func TestFailsOnBadLeeway(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.LeewayEnv, "10")
+	validatePanicsWhenStarting(t)
+}
+
+func TestFailsOnUnknownAlgorithm(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.AllowedAlgorithmsEnv, "RS256,XX512")
+	validatePanicsWhenStarting(t)
+}
+
+func TestFailsOnZeroDPoPProofMaxAge(t *testing.T) {
+	os.Clearenv()
+	tc := dt.NewTest()
+	defaultEnv(tc)
+	os.Setenv(c.DPoPEnabledEnv, "true")
+	os.Setenv(c.DPoPProofMaxAgeEnv, "0s")
+	validatePanicsWhenStarting(t)
+}
+
 func TestFailsOnBadLogLevel(t *testing.T) {
 	os.Clearenv()
 	tc := dt.NewTest()
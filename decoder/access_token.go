@@ -0,0 +1,58 @@
+package decoder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jws"
+)
+
+// AccessTokenType is the JOSE typ header of JWT access tokens (RFC 9068)
+const AccessTokenType = "at+jwt"
+
+// InvalidTokenTypeError is thrown if the typ header of the token is not the expected type
+type InvalidTokenTypeError struct {
+	typ string
+}
+
+func (e InvalidTokenTypeError) Error() string {
+	return fmt.Sprintf("token type '%s' is not allowed", e.typ)
+}
+
+// UnsupportedCriticalHeaderError is thrown if the token has a critical header parameter that is not understood
+type UnsupportedCriticalHeaderError struct {
+	header string
+}
+
+func (e UnsupportedCriticalHeaderError) Error() string {
+	return fmt.Sprintf("critical header '%s' is not supported", e.header)
+}
+
+// WithAccessTokenProfile only accepts JWT access tokens (RFC 9068) with the given typ header,
+// tokens with critical headers and tokens without the client_id, jti and iat claims are rejected
+func WithAccessTokenProfile(typ string) JwsDecoderOption {
+	return func(d *jwsDecoder) {
+		d.tokenType = typ
+		d.policy.required = append(d.policy.required, "client_id", "jti", "iat")
+	}
+}
+
+func (d *jwsDecoder) validateHeaders(headers jws.Headers) error {
+	if d.tokenType == "" {
+		return nil
+	}
+	if !sameMediaType(headers.Type(), d.tokenType) {
+		return InvalidTokenTypeError{headers.Type()}
+	}
+	if crit := headers.Critical(); len(crit) > 0 {
+		return UnsupportedCriticalHeaderError{crit[0]}
+	}
+	return nil
+}
+
+// sameMediaType compares typ headers where the `application/` prefix may be omitted (RFC 7515 4.1.9)
+func sameMediaType(typ, expected string) bool {
+	const prefix = "application/"
+	typ, expected = strings.ToLower(typ), strings.ToLower(expected)
+	return strings.TrimPrefix(typ, prefix) == strings.TrimPrefix(expected, prefix)
+}
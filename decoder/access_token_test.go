@@ -0,0 +1,39 @@
+package decoder_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+	"github.com/lestrrat-go/jwx/jws"
+)
+
+func TestAccessTokenProfile(t *testing.T) {
+	tc := dt.NewTest()
+	claims := map[string]interface{}{"client_id": "client", "jti": "abc", "iat": 1600000000}
+	atHeaders := map[string]interface{}{jws.TypeKey: "at+jwt"}
+	tests := map[string]struct {
+		token []byte
+		err   interface{}
+	}{
+		"AccessToken":          {token: tc.NewTokenWithHeaders(claims, atHeaders)},
+		"MediaTypeAccessToken": {token: tc.NewTokenWithHeaders(claims, map[string]interface{}{jws.TypeKey: "application/AT+JWT"})},
+		"IDToken":              {token: tc.NewValidToken(claims), err: new(decoder.InvalidTokenTypeError)},
+		"CriticalHeader":       {token: tc.NewTokenWithHeaders(claims, map[string]interface{}{jws.TypeKey: "at+jwt", jws.CriticalKey: []string{"exp"}}), err: new(decoder.UnsupportedCriticalHeaderError)},
+		"MissingClientID":      {token: tc.NewTokenWithHeaders(map[string]interface{}{"jti": "abc", "iat": 1600000000}, atHeaders), err: new(decoder.MissingClaimError)},
+		"MissingJwtID":         {token: tc.NewTokenWithHeaders(map[string]interface{}{"client_id": "client", "iat": 1600000000}, atHeaders), err: new(decoder.MissingClaimError)},
+		"MissingIssuedAt":      {token: tc.NewTokenWithHeaders(map[string]interface{}{"client_id": "client", "jti": "abc"}, atHeaders), err: new(decoder.MissingClaimError)},
+	}
+	dec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string), decoder.WithAccessTokenProfile(decoder.AccessTokenType))
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := dec.Decode(dt.Ctx(), string(test.token))
+			if test.err == nil {
+				dt.Report(t, err != nil, "expected token to be valid got %v", err)
+				return
+			}
+			dt.Report(t, !errors.As(err, test.err), "expected error of type %T got %v", test.err, err)
+		})
+	}
+}
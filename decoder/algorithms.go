@@ -0,0 +1,165 @@
+package decoder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+)
+
+// UnsupportedAlgorithmError is thrown if the alg header of the token is not one of the allowed algorithms
+type UnsupportedAlgorithmError struct {
+	alg jwa.SignatureAlgorithm
+}
+
+func (e UnsupportedAlgorithmError) Error() string {
+	return fmt.Sprintf("token algorithm '%s' is not allowed", e.alg)
+}
+
+// AlgorithmMismatchError is thrown if the alg header of the token does not match the key it is signed with
+type AlgorithmMismatchError struct {
+	alg jwa.SignatureAlgorithm
+	kid string
+}
+
+func (e AlgorithmMismatchError) Error() string {
+	return fmt.Sprintf("token algorithm '%s' does not match key '%s'", e.alg, e.kid)
+}
+
+// KeyUsageError is thrown if the key the token is signed with may not be used for verification
+type KeyUsageError struct {
+	kid string
+}
+
+func (e KeyUsageError) Error() string {
+	return fmt.Sprintf("key '%s' may not be used to verify signatures", e.kid)
+}
+
+// WithAlgorithms restricts the accepted tokens to tokens signed with one of the given algorithms,
+// if no algorithms are given all algorithms except `none` are accepted.
+// If given multiple times only the algorithms allowed by all of them are accepted
+func WithAlgorithms(algs ...jwa.SignatureAlgorithm) JwsDecoderOption {
+	return func(d *jwsDecoder) {
+		if len(algs) == 0 {
+			return
+		}
+		allowed := make(map[jwa.SignatureAlgorithm]bool)
+		for _, alg := range algs {
+			if d.algorithms == nil || d.algorithms[alg] {
+				allowed[alg] = true
+			}
+		}
+		d.algorithms = allowed
+	}
+}
+
+func (d *jwsDecoder) allowedAlgorithm(alg jwa.SignatureAlgorithm) bool {
+	if alg == jwa.NoSignature || isHmac(alg) != d.symmetric {
+		return false
+	}
+	return d.algorithms == nil || d.algorithms[alg]
+}
+
+// verify the signature of the token with the key in the set matching its kid, the alg header
+// has to be allowed and consistent with the alg, use and key_ops of the key
+func (d *jwsDecoder) verify(ctx context.Context, rawJws []byte, set jwk.Set) (jws.Headers, error) {
+	msg, err := jws.Parse(rawJws)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse token: %w", err)
+	}
+	if len(msg.Signatures()) != 1 {
+		return nil, fmt.Errorf("token has %d signatures, expected 1", len(msg.Signatures()))
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+	alg := headers.Algorithm()
+	if !d.allowedAlgorithm(alg) {
+		rejectedAlgorithms.WithLabelValues(alg.String()).Inc()
+		return nil, UnsupportedAlgorithmError{alg}
+	}
+	var keyErr error
+	kidFound := false
+	for iter := set.Iterate(ctx); iter.Next(ctx); {
+		key := iter.Pair().Value.(jwk.Key)
+		if kid := headers.KeyID(); kid != "" && key.KeyID() != "" && kid != key.KeyID() {
+			continue
+		}
+		kidFound = kidFound || key.KeyID() != ""
+		if (key.KeyType() == jwa.OctetSeq) != d.symmetric {
+			continue
+		}
+		if err := canVerify(key, alg); err != nil {
+			keyErr = err
+			continue
+		}
+		// the kid has already been matched, verify with the raw key to also allow keys without kid
+		var raw interface{}
+		if err := key.Raw(&raw); err != nil {
+			continue
+		}
+		if _, err := jws.Verify(rawJws, alg, raw); err == nil {
+			reportRetiredKey(ctx, key)
+			return headers, nil
+		}
+	}
+	if keyErr != nil {
+		if _, ok := keyErr.(AlgorithmMismatchError); ok {
+			rejectedAlgorithms.WithLabelValues(alg.String()).Inc()
+		}
+		return nil, keyErr
+	}
+	if kid := headers.KeyID(); kid != "" && !kidFound {
+		return nil, UnknownKeyError{kid}
+	}
+	return nil, fmt.Errorf("unable to verify token with jwks: no matching key")
+}
+
+func canVerify(key jwk.Key, alg jwa.SignatureAlgorithm) error {
+	if usage := key.KeyUsage(); usage != "" && usage != jwk.ForSignature.String() {
+		return KeyUsageError{key.KeyID()}
+	}
+	if ops := key.KeyOps(); len(ops) > 0 && !containsOp(ops, jwk.KeyOpVerify) {
+		return KeyUsageError{key.KeyID()}
+	}
+	if keyAlg := key.Algorithm(); keyAlg != "" {
+		if keyAlg != alg.String() {
+			return AlgorithmMismatchError{alg: alg, kid: key.KeyID()}
+		}
+	} else if !algorithmFitsKeyType(alg, key.KeyType()) {
+		return AlgorithmMismatchError{alg: alg, kid: key.KeyID()}
+	}
+	if secret, ok := key.(jwk.SymmetricKey); ok && isHmac(alg) && len(secret.Octets()) < minHmacSecretLength(alg) {
+		return AlgorithmMismatchError{alg: alg, kid: key.KeyID()}
+	}
+	return nil
+}
+
+func isHmac(alg jwa.SignatureAlgorithm) bool {
+	return alg == jwa.HS256 || alg == jwa.HS384 || alg == jwa.HS512
+}
+
+func containsOp(ops jwk.KeyOperationList, op jwk.KeyOperation) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func algorithmFitsKeyType(alg jwa.SignatureAlgorithm, kty jwa.KeyType) bool {
+	name := alg.String()
+	switch kty {
+	case jwa.RSA:
+		return strings.HasPrefix(name, "RS") || strings.HasPrefix(name, "PS")
+	case jwa.EC:
+		return strings.HasPrefix(name, "ES")
+	case jwa.OKP:
+		return alg == jwa.EdDSA
+	case jwa.OctetSeq:
+		return strings.HasPrefix(name, "HS")
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package decoder
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -9,6 +10,9 @@ import (
 	"github.com/dgraph-io/ristretto"
 )
 
+// maxCacheTTL is the longest time a decoded token or rejection is cached
+const maxCacheTTL = 10 * time.Minute
+
 type cachedJwtDecoder struct {
 	cache    *ristretto.Cache
 	delegate TokenDecoder
@@ -19,7 +23,15 @@ type cacheVal struct {
 	err   error
 }
 
-// NewCachedJwtDecoder returns a new JwtDecoder that will cache Tokens decoded by the delegate
+// temporary is implemented by errors that depend on the state of a key source or remote endpoint
+// rather than on the token itself, decoding the same token again might succeed
+type temporary interface {
+	Temporary() bool
+}
+
+// NewCachedJwtDecoder returns a new JwtDecoder that will cache Tokens decoded by the delegate.
+// Tokens are cached until their `exp` (at most 10 minutes) and rejections for 10 minutes,
+// temporary errors like unreachable endpoints are not cached
 func NewCachedJwtDecoder(cache *ristretto.Cache, delegate TokenDecoder) TokenDecoder {
 	return &cachedJwtDecoder{cache: cache, delegate: delegate}
 }
@@ -31,7 +43,26 @@ func (d *cachedJwtDecoder) Decode(ctx context.Context, raw string) (*Token, erro
 	}
 	log.Ctx(ctx).Trace().Msg("cache miss, resolving token from delegate")
 	token, err := d.delegate.Decode(ctx, raw)
-	toCache := &cacheVal{token: token, err: err}
-	d.cache.SetWithTTL(raw, toCache, 100, 10*time.Minute)
+	if ttl := cacheTTL(token, err); ttl > 0 {
+		d.cache.SetWithTTL(raw, &cacheVal{token: token, err: err}, 100, ttl)
+	}
 	return token, err
 }
+
+// cacheTTL returns how long the result of decoding a token may be cached, or 0 if it must not be cached
+func cacheTTL(token *Token, err error) time.Duration {
+	var t temporary
+	if errors.As(err, &t) && t.Temporary() {
+		return 0
+	}
+	ttl := maxCacheTTL
+	if token != nil && token.maxCacheTTL > 0 && token.maxCacheTTL < ttl {
+		ttl = token.maxCacheTTL
+	}
+	if token != nil && !token.Expiration.IsZero() {
+		if untilExp := time.Until(token.Expiration); untilExp < ttl {
+			return untilExp
+		}
+	}
+	return ttl
+}
@@ -2,7 +2,10 @@ package decoder_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -32,6 +35,45 @@ func TestCacheAllResponses(t *testing.T) {
 	}
 }
 
+func TestCacheUntilExpiration(t *testing.T) {
+	tests := map[string]struct {
+		exp   time.Duration
+		calls int
+	}{
+		"ExpiresBeforeSecondGet": {exp: 50 * time.Millisecond, calls: 2},
+		"AlreadyExpired":         {exp: -time.Second, calls: 2},
+		"ExpiresLater":           {exp: time.Hour, calls: 1},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			token := &decoder.Token{Expiration: time.Now().Add(tc.exp)}
+			delegate := newMock(func(ctx context.Context, raw string) (*decoder.Token, error) {
+				return token, nil
+			})
+			dec := decoder.NewCachedJwtDecoder(dt.Cache, delegate)
+			dec.Decode(dt.Ctx(), name)
+			time.Sleep(100 * time.Millisecond)
+			getAndCompareCached(t, name, dec, delegate, token, nil, tc.calls)
+		})
+	}
+}
+
+func TestDoNotCacheFailedIntrospection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	delegate := newMock(decoder.NewIntrospectionDecoder(server.URL, "client", "secret", make(map[string]string), nil, decoder.ClaimPolicy{}).Decode)
+	dec := decoder.NewCachedJwtDecoder(dt.Cache, delegate)
+	for calls := 1; calls <= 2; calls++ {
+		var introspectionErr decoder.IntrospectionError
+		_, err := dec.Decode(dt.Ctx(), "opaque-unavailable")
+		dt.Report(t, !errors.As(err, &introspectionErr), "expected IntrospectionError got %v", err)
+		dt.Report(t, delegate.calls != calls, "incorrect number of calls to delegate %d expected %d", delegate.calls, calls)
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 func getAndCompareCached(t *testing.T, name string, dec decoder.TokenDecoder, delegate *decoderMock, expectedToken *decoder.Token, expectedError error, expectedCalls int) {
 	token, err := dec.Decode(dt.Ctx(), name)
 	dt.Report(t, err != expectedError, "got unexpected error %v from cache expected %v", err, expectedError)
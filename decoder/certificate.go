@@ -0,0 +1,72 @@
+package decoder
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ClientCertHeader is the header Traefik forwards the client certificate in (passTLSClientCert with pem)
+const ClientCertHeader = "X-Forwarded-Tls-Client-Cert"
+
+// CertificateBindingError is thrown if the token is not bound to the forwarded client certificate
+type CertificateBindingError struct {
+	reason string
+}
+
+func (e CertificateBindingError) Error() string {
+	return fmt.Sprintf("certificate binding mismatch: %s", e.reason)
+}
+
+// WithCertificateBinding only accepts certificate bound tokens (RFC 8705) whose cnf.x5t#S256 claim
+// matches the SHA-256 thumbprint of the client certificate forwarded in the X-Forwarded-Tls-Client-Cert header
+func WithCertificateBinding() ServerOption {
+	return func(s *Server) {
+		s.certificateBinding = true
+	}
+}
+
+// verifyCertificateBinding verifies that the token is bound to the client certificate of the request
+func verifyCertificateBinding(r *http.Request, t *Token) error {
+	if t.CertificateThumbprint == "" {
+		return CertificateBindingError{"token is not bound to a certificate"}
+	}
+	header := r.Header.Get(ClientCertHeader)
+	if header == "" {
+		return CertificateBindingError{"no client certificate"}
+	}
+	cert, err := parseForwardedCert(header)
+	if err != nil {
+		return CertificateBindingError{err.Error()}
+	}
+	thumbprint := sha256.Sum256(cert.Raw)
+	if base64.RawURLEncoding.EncodeToString(thumbprint[:]) != t.CertificateThumbprint {
+		return CertificateBindingError{"token is bound to a different certificate"}
+	}
+	return nil
+}
+
+// parseForwardedCert parses the leaf certificate of the url escaped, comma separated
+// base64 encoded certificates with or without pem delimiters
+func parseForwardedCert(header string) (*x509.Certificate, error) {
+	unescaped, err := url.QueryUnescape(header)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unescape client certificate: %w", err)
+	}
+	leaf := strings.Split(unescaped, ",")[0]
+	leaf = strings.NewReplacer("-----BEGIN CERTIFICATE-----", "", "-----END CERTIFICATE-----", "",
+		"\n", "", "\r", "", " ", "").Replace(leaf)
+	der, err := base64.StdEncoding.DecodeString(leaf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode client certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client certificate: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,45 @@
+package decoder_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func TestCertificateBinding(t *testing.T) {
+	tc := dt.NewTest()
+	jwsDec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string))
+	srv := decoder.NewServer(jwsDec, dt.AuthHeaderKey, dt.TokenValidatedHeaderKey, false, decoder.WithCertificateBinding())
+	cert, thumbprint := dt.NewClientCert()
+	otherCert, _ := dt.NewClientCert()
+	bound := tc.NewValidToken(map[string]interface{}{"cnf": map[string]string{"x5t#S256": thumbprint}})
+	tests := map[string]struct {
+		token []byte
+		cert  string
+		code  int
+	}{
+		"BoundCertificate":            {token: bound, cert: cert, code: http.StatusOK},
+		"CertificateChain":            {token: bound, cert: cert + "," + otherCert, code: http.StatusOK},
+		"OtherCertificate":            {token: bound, cert: otherCert, code: http.StatusUnauthorized},
+		"NoCertificate":               {token: bound, code: http.StatusUnauthorized},
+		"InvalidCertificate":          {token: bound, cert: "invalid", code: http.StatusUnauthorized},
+		"UnboundTokenWithCertificate": {token: tc.NewValidToken(map[string]interface{}{}), cert: cert, code: http.StatusUnauthorized},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(dt.Ctx(), "GET", "/", nil)
+			req.Header.Add(dt.AuthHeaderKey, fmt.Sprintf("Bearer %s", test.token))
+			if test.cert != "" {
+				req.Header.Set(decoder.ClientCertHeader, test.cert)
+			}
+			rr := httptest.NewRecorder()
+			srv.DecodeToken(rr, req)
+			status := rr.Result().StatusCode
+			dt.Report(t, status != test.code, "incorrect server response, %d, expected: %d", status, test.code)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package decoder
+
+import (
+	"strconv"
+	"strings"
+)
+
+// lookupClaim returns the claim at the path, a claim with exactly the name of the path takes precedence.
+// Paths starting with '/' are JSON Pointers (RFC 6901), all other paths are dot separated where '\' escapes
+// a literal '.' or '\'. Numeric segments index into arrays, e.g. `realm_access.roles.0`
+func lookupClaim(get func(string) (interface{}, bool), path string) (interface{}, bool) {
+	if value, ok := get(path); ok {
+		return value, true
+	}
+	segments := claimPath(path)
+	value, ok := get(segments[0])
+	if !ok {
+		return nil, false
+	}
+	for _, segment := range segments[1:] {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if value, ok = v[segment]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			ind, err := strconv.Atoi(segment)
+			if err != nil || ind < 0 || ind >= len(v) {
+				return nil, false
+			}
+			value = v[ind]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// claimPath splits the JSON Pointer or dot path into its unescaped segments
+func claimPath(path string) []string {
+	if strings.HasPrefix(path, "/") {
+		segments := strings.Split(path[1:], "/")
+		for i, segment := range segments {
+			segments[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(segment)
+		}
+		return segments
+	}
+	var segments []string
+	var segment strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path):
+			i++
+			segment.WriteByte(path[i])
+		case path[i] == '.':
+			segments = append(segments, segment.String())
+			segment.Reset()
+		default:
+			segment.WriteByte(path[i])
+		}
+	}
+	return append(segments, segment.String())
+}
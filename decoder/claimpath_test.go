@@ -0,0 +1,63 @@
+package decoder_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func TestNestedClaimMappings(t *testing.T) {
+	tc := dt.NewTest()
+	claims := map[string]interface{}{
+		"realm_access":               map[string]interface{}{"roles": []string{"admin", "user"}},
+		"resource_access":            map[string]interface{}{"my.client": map[string]interface{}{"roles": []string{"reader"}}, "a/b": "slash"},
+		"https://example.com/tenant": "acme",
+		"groups":                     []interface{}{map[string]string{"name": "staff"}},
+		"email":                      "alice@example.com",
+	}
+	tests := map[string]struct {
+		path     string
+		expected string
+	}{
+		"DotPath":         {path: "realm_access.roles", expected: `["admin","user"]`},
+		"ArrayIndex":      {path: "realm_access.roles.1", expected: "user"},
+		"EscapedDot":      {path: `resource_access.my\.client.roles`, expected: `["reader"]`},
+		"JSONPointer":     {path: "/resource_access/my.client/roles/0", expected: "reader"},
+		"EscapedPointer":  {path: "/resource_access/a~1b", expected: "slash"},
+		"TopLevelPointer": {path: "/email", expected: "alice@example.com"},
+		"EscapedTopLevel": {path: "/https:~1~1example.com~1tenant", expected: "acme"},
+		"ObjectInArray":   {path: "groups.0.name", expected: "staff"},
+		"TopLevelWithDot": {path: "https://example.com/tenant", expected: "acme"},
+		"MissingMember":   {path: "realm_access.groups"},
+		"OutOfRange":      {path: "realm_access.roles.2"},
+		"NotAnObject":     {path: "realm_access.roles.admin"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dec, _ := decoder.NewJwsDecoder(tc.JwksURL, map[string]string{test.path: "jwt-token-claim"})
+			token, err := dec.Decode(dt.Ctx(), string(tc.NewValidToken(claims)))
+			dt.Report(t, err != nil, "unable to decode token %s", err)
+			value, ok := token.Claims["jwt-token-claim"]
+			dt.Report(t, ok != (test.expected != ""), "claim at %s is set: %t", test.path, ok)
+			dt.Report(t, value != test.expected, "claim at %s is '%s', expected: '%s'", test.path, value, test.expected)
+		})
+	}
+}
+
+func TestRequiredNestedClaim(t *testing.T) {
+	tc := dt.NewTest()
+	dec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string), decoder.WithRequiredClaims("realm_access.roles"))
+	_, err := dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{"realm_access": map[string]interface{}{"roles": []string{}}})))
+	dt.Report(t, err != nil, "unable to decode token with required nested claim %s", err)
+	_, err = dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{"realm_access": map[string]interface{}{}})))
+	var missingClaimErr decoder.MissingClaimError
+	dt.Report(t, !errors.As(err, &missingClaimErr), "expected MissingClaimError got %v", err)
+
+	dec, _ = decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string), decoder.WithRequiredClaims("/email"))
+	_, err = dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{"email": "alice@example.com"})))
+	dt.Report(t, err != nil, "unable to decode token with required top level pointer %s", err)
+	_, err = dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{})))
+	dt.Report(t, !errors.As(err, &missingClaimErr), "expected MissingClaimError got %v", err)
+}
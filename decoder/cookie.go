@@ -0,0 +1,65 @@
+package decoder
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// WithCookies also reads the token from the cookies with the names (in order) after the auth header.
+// A token that is too large for one cookie can be split in chunks named <name>.0, <name>.1, ... which are joined in order
+func WithCookies(names ...string) ServerOption {
+	return func(s *Server) {
+		s.cookieNames = append(s.cookieNames, names...)
+	}
+}
+
+type cookieSource struct {
+	name string
+}
+
+// CookieSource returns a TokenSource for the (chunked) cookie
+func CookieSource(name string) TokenSource {
+	return &cookieSource{name: name}
+}
+
+func (s *cookieSource) credential(r *http.Request) (credential, bool, error) {
+	cookies := make(map[string]string)
+	for _, cookie := range r.Cookies() {
+		if _, ok := cookies[cookie.Name]; ok && (cookie.Name == s.name || s.isChunk(cookie.Name)) {
+			return credential{}, false, AmbiguousCredentialsError{fmt.Sprintf("cookie %s is set more than once", cookie.Name)}
+		}
+		cookies[cookie.Name] = cookie.Value
+	}
+	var token string
+	for i := 0; ; i++ {
+		chunk, ok := cookies[s.name+"."+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		token += chunk
+	}
+	if value, ok := cookies[s.name]; ok {
+		if token != "" {
+			return credential{}, false, AmbiguousCredentialsError{fmt.Sprintf("cookie %s is set whole and in chunks", s.name)}
+		}
+		token = value
+	}
+	if token == "" {
+		return credential{}, false, nil
+	}
+	return credential{token: token, source: s.String()}, true, nil
+}
+
+// isChunk returns true if the cookie is a chunk of the token cookie
+func (s *cookieSource) isChunk(name string) bool {
+	if len(name) <= len(s.name)+1 || name[:len(s.name)+1] != s.name+"." {
+		return false
+	}
+	_, err := strconv.Atoi(name[len(s.name)+1:])
+	return err == nil
+}
+
+func (s *cookieSource) String() string {
+	return "cookie:" + s.name
+}
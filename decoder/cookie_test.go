@@ -0,0 +1,55 @@
+package decoder_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func TestTokenCookies(t *testing.T) {
+	tc := dt.NewTest()
+	jwsDec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string))
+	srv := decoder.NewServer(jwsDec, dt.AuthHeaderKey, dt.TokenValidatedHeaderKey, false, decoder.WithCookies("access_token", "session"))
+	valid := string(tc.NewValidToken(map[string]interface{}{}))
+	invalid := string(tc.NewInvalidToken(map[string]interface{}{}))
+	half := len(valid) / 2
+	tests := map[string]struct {
+		header    string
+		cookies   map[string]string
+		code      int
+		validated string
+	}{
+		"Cookie":               {cookies: map[string]string{"access_token": valid}, code: http.StatusOK, validated: "true"},
+		"SecondCookie":         {cookies: map[string]string{"session": valid}, code: http.StatusOK, validated: "true"},
+		"ChunkedCookie":        {cookies: map[string]string{"access_token.0": valid[:half], "access_token.1": valid[half:]}, code: http.StatusOK, validated: "true"},
+		"IncompleteChunks":     {cookies: map[string]string{"access_token.0": valid[:half], "access_token.2": valid[half:]}, code: http.StatusUnauthorized},
+		"InvalidCookie":        {cookies: map[string]string{"access_token": invalid}, code: http.StatusUnauthorized},
+		"FirstCookieFirst":     {cookies: map[string]string{"access_token": invalid, "session": valid}, code: http.StatusUnauthorized},
+		"HeaderBeforeCookie":   {header: invalid, cookies: map[string]string{"access_token": valid}, code: http.StatusUnauthorized},
+		"ValidHeaderAndCookie": {header: valid, cookies: map[string]string{"access_token": invalid}, code: http.StatusOK, validated: "true"},
+		"SameHeaderAndCookie":  {header: valid, cookies: map[string]string{"access_token": valid}, code: http.StatusOK, validated: "true"},
+		"WholeAndChunked":      {cookies: map[string]string{"access_token": valid, "access_token.0": valid}, code: http.StatusUnauthorized},
+		"OtherCookie":          {cookies: map[string]string{"other": valid}, code: http.StatusOK, validated: "false"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(dt.Ctx(), "GET", "/", nil)
+			if test.header != "" {
+				req.Header.Add(dt.AuthHeaderKey, fmt.Sprintf("Bearer %s", test.header))
+			}
+			for name, value := range test.cookies {
+				req.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+			rr := httptest.NewRecorder()
+			srv.DecodeToken(rr, req)
+			status := rr.Result().StatusCode
+			dt.Report(t, status != test.code, "incorrect server response, %d, expected: %d", status, test.code)
+			validated := rr.Result().Header.Get(dt.TokenValidatedHeaderKey)
+			dt.Report(t, validated != test.validated, "header '%s' is '%s', expected: %s", dt.TokenValidatedHeaderKey, validated, test.validated)
+		})
+	}
+}
@@ -11,10 +11,36 @@ type TokenDecoder interface {
 	Decode(ctx context.Context, raw string) (*Token, error)
 }
 
-// Token contains the expiration time and a remapped map of claims from the JWT Token
+// Token contains the time claims, the jti and sub claims, the key or certificate the token is bound to
+// and a remapped map of claims from the JWT Token
 type Token struct {
-	Claims     map[string]string
-	Expiration time.Time
+	Claims                map[string]string
+	ID                    string
+	Subject               string
+	Expiration            time.Time
+	NotBefore             time.Time
+	IssuedAt              time.Time
+	AuthTime              time.Time
+	KeyThumbprint         string
+	CertificateThumbprint string
+	// values are the claims of Claims by header before they are rendered as string
+	values map[string]interface{}
+	// formats are the claim formats of the decoder that mapped the claims, if nil the formats of the server are used
+	formats map[string]ClaimFormat
+	// maxCacheTTL is the longest time the token may be cached if it is shorter than the default
+	maxCacheTTL time.Duration
+}
+
+// Validation configures how the time claims of a token are validated
+type Validation struct {
+	// Leeway is the tolerated clock skew applied to the exp, nbf and iat claims
+	Leeway time.Duration
+	// MaxLifetime is the maximum allowed time between the iat and exp claims, zero disables the check
+	MaxLifetime time.Duration
+	// MaxAge is the maximum allowed time since the iat claim, zero disables the check
+	MaxAge time.Duration
+	// MaxAuthAge is the maximum allowed time since the auth_time claim, zero disables the check
+	MaxAuthAge time.Duration
 }
 
 // TokenExpiredError means the token is invalid because it has expired
@@ -26,10 +52,89 @@ func (e TokenExpiredError) Error() string {
 	return fmt.Sprintf("token is expired (expired at: %s)", e.expiredAt.Format(time.RFC3339))
 }
 
-// Validate the token (currently only checks the expirationTime but could potentially do more checks)
-func (t *Token) Validate() error {
-	if !t.Expiration.IsZero() && time.Now().After(t.Expiration) {
+// TokenNotYetValidError means the token is invalid because it is used before its nbf claim
+type TokenNotYetValidError struct {
+	notBefore time.Time
+}
+
+func (e TokenNotYetValidError) Error() string {
+	return fmt.Sprintf("token is not valid yet (not before: %s)", e.notBefore.Format(time.RFC3339))
+}
+
+// TokenIssuedInFutureError means the token is invalid because its iat claim is in the future
+type TokenIssuedInFutureError struct {
+	issuedAt time.Time
+}
+
+func (e TokenIssuedInFutureError) Error() string {
+	return fmt.Sprintf("token is issued in the future (issued at: %s)", e.issuedAt.Format(time.RFC3339))
+}
+
+// TokenLifetimeExceededError means the token is invalid because the time between iat and exp is too long
+type TokenLifetimeExceededError struct {
+	lifetime, maxLifetime time.Duration
+}
+
+func (e TokenLifetimeExceededError) Error() string {
+	return fmt.Sprintf("token lifetime %s exceeds the maximum lifetime %s", e.lifetime, e.maxLifetime)
+}
+
+// TokenTooOldError means the token is invalid because its iat or auth_time claim is too long ago
+type TokenTooOldError struct {
+	claim  string
+	at     time.Time
+	maxAge time.Duration
+}
+
+func (e TokenTooOldError) Error() string {
+	return fmt.Sprintf("token %s %s is older than the maximum age %s", e.claim, e.at.Format(time.RFC3339), e.maxAge)
+}
+
+// InvalidAudienceError means the token is invalid because its audience does not match the expected audiences
+type InvalidAudienceError struct {
+	audience []string
+}
+
+func (e InvalidAudienceError) Error() string {
+	return fmt.Sprintf("token audience %q does not match the expected audiences", e.audience)
+}
+
+// Validate the time claims of the token (exp, nbf and iat) allowing for the configured leeway
+// and the lifetime and age of the token if the limits are configured
+func (t *Token) Validate(v Validation) error {
+	now := time.Now()
+	if !t.Expiration.IsZero() && now.Add(-v.Leeway).After(t.Expiration) {
 		return TokenExpiredError{t.Expiration}
 	}
+	if !t.NotBefore.IsZero() && now.Add(v.Leeway).Before(t.NotBefore) {
+		return TokenNotYetValidError{t.NotBefore}
+	}
+	if !t.IssuedAt.IsZero() && now.Add(v.Leeway).Before(t.IssuedAt) {
+		return TokenIssuedInFutureError{t.IssuedAt}
+	}
+	if v.MaxLifetime > 0 || v.MaxAge > 0 {
+		if t.IssuedAt.IsZero() {
+			return MissingClaimError{"iat"}
+		}
+		if v.MaxLifetime > 0 {
+			if t.Expiration.IsZero() {
+				return MissingClaimError{"exp"}
+			}
+			if lifetime := t.Expiration.Sub(t.IssuedAt); lifetime > v.MaxLifetime {
+				return TokenLifetimeExceededError{lifetime: lifetime, maxLifetime: v.MaxLifetime}
+			}
+		}
+		if v.MaxAge > 0 && now.Sub(t.IssuedAt) > v.MaxAge+v.Leeway {
+			return TokenTooOldError{claim: "iat", at: t.IssuedAt, maxAge: v.MaxAge}
+		}
+	}
+	if v.MaxAuthAge > 0 {
+		if t.AuthTime.IsZero() {
+			return MissingClaimError{"auth_time"}
+		}
+		if now.Sub(t.AuthTime) > v.MaxAuthAge+v.Leeway {
+			return TokenTooOldError{claim: "auth_time", at: t.AuthTime, maxAge: v.MaxAuthAge}
+		}
+	}
 	return nil
 }
@@ -0,0 +1,51 @@
+package decoder_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func TestTokenTimeValidation(t *testing.T) {
+	now := time.Now()
+	tests := map[string]struct {
+		token      decoder.Token
+		leeway     time.Duration
+		validation decoder.Validation
+		err        interface{}
+	}{
+		"NoTimeClaims":          {token: decoder.Token{}},
+		"ValidTimeClaims":       {token: decoder.Token{Expiration: now.Add(time.Hour), NotBefore: now.Add(-time.Hour), IssuedAt: now.Add(-time.Hour)}},
+		"Expired":               {token: decoder.Token{Expiration: now.Add(-time.Minute)}, err: new(decoder.TokenExpiredError)},
+		"ExpiredWithinLeeway":   {token: decoder.Token{Expiration: now.Add(-time.Minute)}, leeway: 2 * time.Minute},
+		"NotYetValid":           {token: decoder.Token{NotBefore: now.Add(time.Minute)}, err: new(decoder.TokenNotYetValidError)},
+		"NotYetValidInLeeway":   {token: decoder.Token{NotBefore: now.Add(time.Minute)}, leeway: 2 * time.Minute},
+		"IssuedInFuture":        {token: decoder.Token{IssuedAt: now.Add(time.Minute)}, err: new(decoder.TokenIssuedInFutureError)},
+		"IssuedInFutureLeeway":  {token: decoder.Token{IssuedAt: now.Add(time.Minute)}, leeway: 2 * time.Minute},
+		"IssuedOutsideOfLeeway": {token: decoder.Token{IssuedAt: now.Add(time.Hour)}, leeway: 2 * time.Minute, err: new(decoder.TokenIssuedInFutureError)},
+		"ShortLifetime":         {token: decoder.Token{IssuedAt: now, Expiration: now.Add(time.Hour)}, validation: decoder.Validation{MaxLifetime: time.Hour}},
+		"LongLifetime":          {token: decoder.Token{IssuedAt: now, Expiration: now.Add(30 * 24 * time.Hour)}, validation: decoder.Validation{MaxLifetime: time.Hour}, err: new(decoder.TokenLifetimeExceededError)},
+		"LifetimeWithoutIat":    {token: decoder.Token{Expiration: now.Add(time.Hour)}, validation: decoder.Validation{MaxLifetime: time.Hour}, err: new(decoder.MissingClaimError)},
+		"LifetimeWithoutExp":    {token: decoder.Token{IssuedAt: now}, validation: decoder.Validation{MaxLifetime: time.Hour}, err: new(decoder.MissingClaimError)},
+		"RecentlyIssued":        {token: decoder.Token{IssuedAt: now.Add(-time.Minute)}, validation: decoder.Validation{MaxAge: time.Hour}},
+		"IssuedTooLongAgo":      {token: decoder.Token{IssuedAt: now.Add(-2 * time.Hour), Expiration: now.Add(time.Hour)}, validation: decoder.Validation{MaxAge: time.Hour}, err: new(decoder.TokenTooOldError)},
+		"RecentlyAuthenticated": {token: decoder.Token{AuthTime: now.Add(-time.Minute)}, validation: decoder.Validation{MaxAuthAge: time.Hour}},
+		"AuthenticatedLongAgo":  {token: decoder.Token{AuthTime: now.Add(-2 * time.Hour)}, validation: decoder.Validation{MaxAuthAge: time.Hour}, err: new(decoder.TokenTooOldError)},
+		"MissingAuthTime":       {token: decoder.Token{}, validation: decoder.Validation{MaxAuthAge: time.Hour}, err: new(decoder.MissingClaimError)},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			validation := test.validation
+			validation.Leeway = test.leeway
+			err := test.token.Validate(validation)
+			if test.err == nil {
+				dt.Report(t, err != nil, "expected token to be valid got %v", err)
+				return
+			}
+			dt.Report(t, !errors.As(err, test.err), "expected error of type %T got %v", test.err, err)
+		})
+	}
+}
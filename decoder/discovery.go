@@ -0,0 +1,196 @@
+package decoder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// discoveryRetryInterval is the minimum time between fetching the discovery document for requests while there is none
+const discoveryRetryInterval = time.Second
+
+// Discovery is the subset of the OpenID provider metadata used to configure the decoder
+type Discovery struct {
+	Issuer  string `json:"issuer"`
+	JwksURI string `json:"jwks_uri"`
+}
+
+// DiscoveryURL returns the url of the OpenID provider metadata of the issuer
+func DiscoveryURL(issuerURL string) string {
+	return strings.TrimSuffix(issuerURL, "/") + discoveryPath
+}
+
+type discoveryDecoder struct {
+	issuerURL    string
+	claimMapping map[string]string
+	opts         []JwsDecoderOption
+	remoteOpts   []RemoteKeySourceOption
+	client       *http.Client
+	ctx          context.Context
+	cancel       context.CancelFunc
+	mutex        sync.RWMutex
+	discovery    *Discovery
+	delegate     TokenDecoder
+	refreshMutex sync.Mutex
+	keys         *remoteKeySource
+	retryMutex   sync.Mutex
+	lastRetry    time.Time
+	retryErr     error
+}
+
+// NewDiscoveryDecoder returns a JWS Decoder configured from the OpenID provider metadata of the issuer,
+// the tokens are verified with the keys at `jwks_uri` and have to be issued by the `issuer`. The metadata is
+// fetched with the client (the default client if nil) and refreshed every refreshInterval (never if it is 0),
+// the keys are fetched with the client and the remoteOpts and only from a new source if the `jwks_uri` changes.
+// If the metadata can't be fetched the decoder is returned with an error and will try again on the next refresh
+// or decoded token, at most once a second
+func NewDiscoveryDecoder(issuerURL string, claimMapping map[string]string, refreshInterval time.Duration, client *http.Client,
+	remoteOpts []RemoteKeySourceOption, opts ...JwsDecoderOption) (TokenDecoder, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	d := &discoveryDecoder{issuerURL: issuerURL, claimMapping: claimMapping, opts: opts, client: client,
+		remoteOpts: append([]RemoteKeySourceOption{WithHTTPClient(client)}, remoteOpts...)}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	err := d.refresh(d.ctx)
+	d.lastRetry, d.retryErr = time.Now(), err
+	if refreshInterval > 0 {
+		go d.refreshLoop(refreshInterval)
+	}
+	h := &discoveryHandle{d}
+	runtime.SetFinalizer(h, func(h *discoveryHandle) { h.close() })
+	return h, err
+}
+
+// discoveryHandle is the decoder returned by NewDiscoveryDecoder, the refresh loop only references the wrapped
+// decoder so it is stopped once the handle is unreachable
+type discoveryHandle struct {
+	*discoveryDecoder
+}
+
+func (d *discoveryDecoder) Decode(ctx context.Context, raw string) (*Token, error) {
+	d.mutex.RLock()
+	delegate := d.delegate
+	d.mutex.RUnlock()
+	if delegate == nil {
+		if err := d.retry(ctx); err != nil {
+			return nil, KeySourceError{jwksURL: DiscoveryURL(d.issuerURL), err: err}
+		}
+		d.mutex.RLock()
+		delegate = d.delegate
+		d.mutex.RUnlock()
+	}
+	return delegate.Decode(ctx, raw)
+}
+
+// retry refreshes the discovery document for a request while there is none, the document is fetched at most once
+// every discoveryRetryInterval so requests can't be used to overload the issuer, otherwise the last error is returned
+func (d *discoveryDecoder) retry(ctx context.Context) error {
+	d.retryMutex.Lock()
+	defer d.retryMutex.Unlock()
+	if time.Since(d.lastRetry) < discoveryRetryInterval {
+		return d.retryErr
+	}
+	d.lastRetry = time.Now()
+	d.retryErr = d.refresh(ctx)
+	return d.retryErr
+}
+
+// refreshLoop refreshes the discovery document every interval until the decoder is closed
+func (d *discoveryDecoder) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.refresh(d.ctx); err != nil {
+				log.Warn().Err(err).Str("issuer", d.issuerURL).Msg("unable to refresh discovery document")
+			}
+		}
+	}
+}
+
+// close stops refreshing the discovery document and the keys
+func (d *discoveryDecoder) close() {
+	d.cancel()
+	d.refreshMutex.Lock()
+	defer d.refreshMutex.Unlock()
+	if d.keys != nil {
+		d.keys.close()
+	}
+}
+
+// refresh fetches the discovery document and replaces the delegate if the document has changed,
+// the key source (with its stale and retired keys) is kept unless the `jwks_uri` has changed
+func (d *discoveryDecoder) refresh(ctx context.Context) error {
+	d.refreshMutex.Lock()
+	defer d.refreshMutex.Unlock()
+	discovery, err := d.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	d.mutex.RLock()
+	previous := d.discovery
+	d.mutex.RUnlock()
+	if previous != nil && previous.equal(discovery) {
+		return nil
+	}
+	keys := d.keys
+	var replaced *remoteKeySource
+	if previous == nil || previous.JwksURI != discovery.JwksURI {
+		replaced = d.keys
+		keys, err = newRemoteKeySource(discovery.JwksURI, d.remoteOpts...)
+	}
+	opts := append([]JwsDecoderOption{WithIssuers(discovery.Issuer)}, d.opts...)
+	delegate := NewKeySourceDecoder(keys, d.claimMapping, opts...)
+	d.mutex.Lock()
+	d.discovery, d.delegate, d.keys = discovery, delegate, keys
+	d.mutex.Unlock()
+	if replaced != nil {
+		replaced.close()
+	}
+	log.Info().Str("issuer", discovery.Issuer).Str("jwks", discovery.JwksURI).Msg("configured from discovery document")
+	return err
+}
+
+func (d *discoveryDecoder) fetch(ctx context.Context) (*Discovery, error) {
+	url := DiscoveryURL(d.issuerURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch discovery document %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch discovery document %s: status %d", url, resp.StatusCode)
+	}
+	var discovery Discovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("unable to decode discovery document %s: %w", url, err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(d.issuerURL, "/") {
+		return nil, fmt.Errorf("discovery document issuer '%s' does not match '%s'", discovery.Issuer, d.issuerURL)
+	}
+	if discovery.JwksURI == "" {
+		return nil, fmt.Errorf("discovery document %s has no jwks_uri", url)
+	}
+	return &discovery, nil
+}
+
+func (d *Discovery) equal(other *Discovery) bool {
+	return d.Issuer == other.Issuer && d.JwksURI == other.JwksURI
+}
@@ -0,0 +1,160 @@
+package decoder_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+	"github.com/lestrrat-go/jwx/jwa"
+)
+
+func TestDiscoveryDecoder(t *testing.T) {
+	tc := dt.NewTest()
+	dec, err := decoder.NewDiscoveryDecoder(tc.IssuerURL, map[string]string{"email": "jwt-token-email"}, time.Hour, nil, nil)
+	dt.Report(t, err != nil, "unable to create discovery decoder %s", err)
+
+	token, err := dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{"iss": tc.IssuerURL, "email": "a@b.c"})))
+	dt.Report(t, err != nil, "unable to decode token from discovered issuer %s", err)
+	dt.Report(t, token.Claims["jwt-token-email"] != "a@b.c", "incorrect claims %v", token.Claims)
+
+	_, err = dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{"iss": "https://other"})))
+	var issErr decoder.InvalidIssuerError
+	dt.Report(t, !errors.As(err, &issErr), "expected InvalidIssuerError got %v", err)
+
+	_, err = dec.Decode(dt.Ctx(), string(tc.NewTokenSignedWith(map[string]interface{}{"iss": tc.IssuerURL}, jwa.HS256, []byte("secret"))))
+	var algErr decoder.UnsupportedAlgorithmError
+	dt.Report(t, !errors.As(err, &algErr), "expected UnsupportedAlgorithmError for HMAC with a public key got %v", err)
+}
+
+func TestDiscoveryDecoderWithUnreachableIssuer(t *testing.T) {
+	dec, err := decoder.NewDiscoveryDecoder("http://0.0.0.0:1", make(map[string]string), time.Hour, nil, nil)
+	dt.Report(t, dec == nil || err == nil, "expected decoder and error for unreachable issuer got %v %v", dec, err)
+	_, err = dec.Decode(dt.Ctx(), "token")
+	dt.Report(t, err == nil, "expected error decoding without discovery document")
+}
+
+func TestDiscoveryDecoderWithHTTPClient(t *testing.T) {
+	tc := dt.NewTest()
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(decoder.Discovery{Issuer: server.URL, JwksURI: server.URL + "/jwks.json"})
+	})
+	mux.HandleFunc("/jwks.json", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write(tc.JWKS())
+	})
+
+	_, err := decoder.NewDiscoveryDecoder(server.URL, make(map[string]string), time.Hour, nil, nil)
+	dt.Report(t, err == nil, "expected error fetching discovery document with untrusted certificate")
+
+	dec, err := decoder.NewDiscoveryDecoder(server.URL, make(map[string]string), time.Hour, server.Client(), nil)
+	dt.Report(t, err != nil, "unable to create discovery decoder with http client %s", err)
+	_, err = dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{"iss": server.URL})))
+	dt.Report(t, err != nil, "unable to decode token with keys fetched by http client %s", err)
+}
+
+type discoveryServer struct {
+	mutex       sync.Mutex
+	server      *httptest.Server
+	discoveries int
+	down        bool
+	algorithms  []string
+	jwksPath    string
+	jwks        *rotatingJwksServer
+}
+
+func newDiscoveryServer(jwks *rotatingJwksServer) *discoveryServer {
+	s := &discoveryServer{jwks: jwks, jwksPath: "/jwks.json", algorithms: []string{"RS256"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.down {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		s.discoveries++
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"issuer":                                s.server.URL,
+			"jwks_uri":                              s.server.URL + s.jwksPath,
+			"id_token_signing_alg_values_supported": s.algorithms,
+		})
+	})
+	mux.Handle("/", jwks)
+	s.server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *discoveryServer) update(f func(s *discoveryServer)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f(s)
+}
+
+func (s *discoveryServer) discoveryCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.discoveries
+}
+
+func TestDiscoveryRetriesAreRateLimited(t *testing.T) {
+	tc := dt.NewTest()
+	server := newDiscoveryServer(&rotatingJwksServer{jwks: tc.JWKS()})
+	defer server.server.Close()
+	server.update(func(s *discoveryServer) { s.down = true })
+	dec, err := decoder.NewDiscoveryDecoder(server.server.URL, make(map[string]string), time.Hour, nil, nil)
+	dt.Report(t, err == nil, "expected error while the discovery document is unavailable")
+	server.update(func(s *discoveryServer) { s.down = false })
+	token := string(tc.NewValidToken(map[string]interface{}{"iss": server.server.URL}))
+
+	for i := 0; i < 10; i++ {
+		_, err = dec.Decode(dt.Ctx(), token)
+		var keySourceErr decoder.KeySourceError
+		dt.Report(t, !errors.As(err, &keySourceErr), "expected KeySourceError within the retry interval got %v", err)
+	}
+	dt.Report(t, server.discoveryCount() != 0, "expected no discovery fetches within the retry interval got %d", server.discoveryCount())
+
+	time.Sleep(time.Second)
+	_, err = dec.Decode(dt.Ctx(), token)
+	dt.Report(t, err != nil, "expected token to be valid after the retry interval got %v", err)
+}
+
+func TestDiscoveryKeepsKeySource(t *testing.T) {
+	before, after := dt.NewTest(), dt.NewTest()
+	jwks := &rotatingJwksServer{jwks: before.JWKS()}
+	server := newDiscoveryServer(jwks)
+	defer server.server.Close()
+	dec, err := decoder.NewDiscoveryDecoder(server.server.URL, make(map[string]string), 20*time.Millisecond, nil, nil)
+	dt.HandleByPanic(err)
+
+	time.Sleep(200 * time.Millisecond)
+	_, err = dec.Decode(dt.Ctx(), string(before.NewValidToken(map[string]interface{}{"iss": server.server.URL})))
+	dt.Report(t, err != nil, "unable to decode token after discovery changes %s", err)
+	dt.Report(t, server.discoveryCount() < 5, "expected the discovery document to be refreshed got %d fetches", server.discoveryCount())
+	dt.Report(t, jwks.fetchCount() != 1, "expected the keys to be fetched once for the same jwks_uri got %d fetches", jwks.fetchCount())
+
+	jwks.rotate(after.JWKS())
+	server.update(func(s *discoveryServer) { s.jwksPath = "/rotated.json" })
+	time.Sleep(100 * time.Millisecond)
+	_, err = dec.Decode(dt.Ctx(), string(after.NewValidToken(map[string]interface{}{"iss": server.server.URL})))
+	dt.Report(t, err != nil, "expected keys of the new jwks_uri got %v", err)
+}
+
+func TestDiscoveryIgnoresIDTokenAlgorithms(t *testing.T) {
+	tc := dt.NewTest()
+	server := newDiscoveryServer(&rotatingJwksServer{jwks: tc.JWKS()})
+	defer server.server.Close()
+	server.update(func(s *discoveryServer) { s.algorithms = []string{"ES256"} })
+	dec, err := decoder.NewDiscoveryDecoder(server.server.URL, make(map[string]string), 0, nil, nil)
+	dt.HandleByPanic(err)
+	_, err = dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{"iss": server.server.URL})))
+	dt.Report(t, err != nil, "expected RS256 access token to be valid with ES256 id tokens got %v", err)
+	dt.Report(t, server.discoveryCount() != 1, "expected a single discovery fetch without refresh interval got %d", server.discoveryCount())
+}
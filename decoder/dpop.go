@@ -0,0 +1,253 @@
+package decoder
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+const (
+	// DPoPScheme is the authorization scheme of DPoP bound access tokens
+	DPoPScheme = "DPoP"
+	// DPoPHeader is the header with the DPoP proof
+	DPoPHeader = "DPoP"
+
+	dpopProofType = "dpop+jwt"
+
+	// dpopPruneInterval is how often the used jtis of expired proofs are dropped
+	dpopPruneInterval = 10 * time.Second
+	// maxDPoPProofs is the maximum number of used jtis that are remembered, proofs are rejected while it is reached
+	maxDPoPProofs = 1 << 18
+)
+
+// InvalidProofError is thrown if the DPoP proof of the request is missing or invalid
+type InvalidProofError struct {
+	reason string
+}
+
+func (e InvalidProofError) Error() string {
+	return fmt.Sprintf("invalid DPoP proof: %s", e.reason)
+}
+
+// ProofReplayedError is thrown if the jti of the DPoP proof has already been used
+type ProofReplayedError struct {
+	jti string
+}
+
+func (e ProofReplayedError) Error() string {
+	return fmt.Sprintf("DPoP proof '%s' has already been used", e.jti)
+}
+
+// TokenBindingError is thrown if the token is not bound to the key of the DPoP proof
+type TokenBindingError struct {
+	reason string
+}
+
+func (e TokenBindingError) Error() string {
+	return fmt.Sprintf("token binding mismatch: %s", e.reason)
+}
+
+type dpopVerifier struct {
+	maxAge time.Duration
+	mutex  sync.Mutex
+	seen   map[string]time.Time
+}
+
+// WithDPoP accepts DPoP bound tokens (RFC 9449) with the DPoP authorization scheme, the DPoP proof has to be
+// signed by the key the token is bound to (cnf.jkt) for the method and uri of the request (X-Forwarded-Method
+// and X-Forwarded-Uri), be issued within maxProofAge (and the leeway) and may only be used once.
+// DPoP bound tokens are rejected with the Bearer scheme
+func WithDPoP(maxProofAge time.Duration) ServerOption {
+	return func(s *Server) {
+		v := &dpopVerifier{maxAge: maxProofAge, seen: make(map[string]time.Time)}
+		go v.pruneLoop(dpopPruneInterval)
+		s.dpop = v
+	}
+}
+
+// verify the DPoP proof of the request for the access token, dpop is true if the token was sent with the DPoP scheme
+func (v *dpopVerifier) verify(r *http.Request, accessToken string, t *Token, dpop bool, leeway time.Duration) error {
+	if !dpop {
+		if t.KeyThumbprint != "" {
+			return TokenBindingError{"DPoP bound token used as bearer token"}
+		}
+		return nil
+	}
+	if t.KeyThumbprint == "" {
+		return TokenBindingError{"token is not bound to a DPoP key"}
+	}
+	proofs := r.Header.Values(DPoPHeader)
+	if len(proofs) != 1 {
+		return InvalidProofError{fmt.Sprintf("expected 1 proof got %d", len(proofs))}
+	}
+	msg, err := jws.ParseString(proofs[0])
+	if err != nil {
+		return InvalidProofError{err.Error()}
+	}
+	if len(msg.Signatures()) != 1 {
+		return InvalidProofError{fmt.Sprintf("proof has %d signatures, expected 1", len(msg.Signatures()))}
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+	if !sameMediaType(headers.Type(), dpopProofType) {
+		return InvalidProofError{fmt.Sprintf("unexpected typ '%s'", headers.Type())}
+	}
+	key := headers.JWK()
+	if key == nil {
+		return InvalidProofError{"missing jwk header"}
+	}
+	alg := headers.Algorithm()
+	if alg == jwa.NoSignature || isHmac(alg) || !algorithmFitsKeyType(alg, key.KeyType()) {
+		return InvalidProofError{fmt.Sprintf("algorithm '%s' is not allowed", alg)}
+	}
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return InvalidProofError{err.Error()}
+	}
+	switch raw.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return InvalidProofError{"jwk header is not a public key"}
+	}
+	payload, err := jws.Verify([]byte(proofs[0]), alg, raw)
+	if err != nil {
+		return InvalidProofError{err.Error()}
+	}
+	proof, err := jwt.Parse(payload)
+	if err != nil {
+		return InvalidProofError{err.Error()}
+	}
+	if err := v.verifyClaims(r, accessToken, proof, leeway); err != nil {
+		return err
+	}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return InvalidProofError{err.Error()}
+	}
+	if base64.RawURLEncoding.EncodeToString(thumbprint) != t.KeyThumbprint {
+		return TokenBindingError{"DPoP proof is not signed with the key the token is bound to"}
+	}
+	return v.use(proof.JwtID(), proof.IssuedAt().Add(v.maxAge+leeway))
+}
+
+func (v *dpopVerifier) verifyClaims(r *http.Request, accessToken string, proof jwt.Token, leeway time.Duration) error {
+	if proof.JwtID() == "" {
+		return InvalidProofError{"missing jti"}
+	}
+	if htm, _ := proof.Get("htm"); htm != forwardedMethod(r) {
+		return InvalidProofError{fmt.Sprintf("htm '%v' does not match the request", htm)}
+	}
+	if htu, _ := proof.Get("htu"); !sameURI(htu, forwardedURI(r)) {
+		return InvalidProofError{fmt.Sprintf("htu '%v' does not match the request", htu)}
+	}
+	iat := proof.IssuedAt()
+	if iat.IsZero() {
+		return InvalidProofError{"missing iat"}
+	}
+	if now := time.Now(); iat.After(now.Add(leeway)) || iat.Before(now.Add(-v.maxAge-leeway)) {
+		return InvalidProofError{fmt.Sprintf("iat %s is outside of the accepted window", iat.Format(time.RFC3339))}
+	}
+	hash := sha256.Sum256([]byte(accessToken))
+	if ath, _ := proof.Get("ath"); ath != base64.RawURLEncoding.EncodeToString(hash[:]) {
+		return InvalidProofError{"ath does not match the access token"}
+	}
+	return nil
+}
+
+// use marks the jti as used until exp and returns an error if it has already been used, or if maxDPoPProofs
+// jtis are in use as they can't be forgotten before their proofs expire without allowing replays
+func (v *dpopVerifier) use(jti string, exp time.Time) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if _, ok := v.seen[jti]; ok {
+		return ProofReplayedError{jti}
+	}
+	if len(v.seen) >= maxDPoPProofs {
+		v.prune(time.Now())
+		if len(v.seen) >= maxDPoPProofs {
+			return InvalidProofError{"too many proofs in use"}
+		}
+	}
+	v.seen[jti] = exp
+	return nil
+}
+
+// pruneLoop prunes the used jtis every interval
+func (v *dpopVerifier) pruneLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		v.mutex.Lock()
+		v.prune(time.Now())
+		v.mutex.Unlock()
+	}
+}
+
+// prune drops the used jtis of proofs that are too old to be accepted anyway, the mutex has to be held
+func (v *dpopVerifier) prune(now time.Time) {
+	for jti, exp := range v.seen {
+		if now.After(exp) {
+			delete(v.seen, jti)
+		}
+	}
+}
+
+func forwardedMethod(r *http.Request) string {
+	if method := r.Header.Get("X-Forwarded-Method"); method != "" {
+		return method
+	}
+	return r.Method
+}
+
+// forwardedURI returns the uri of the original request from the X-Forwarded headers
+func forwardedURI(r *http.Request) *url.URL {
+	u := &url.URL{Scheme: r.Header.Get("X-Forwarded-Proto"), Host: r.Header.Get("X-Forwarded-Host")}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if r.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	if u.Host == "" {
+		u.Host = r.Host
+	}
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	if parsed, err := url.Parse(uri); err == nil {
+		u.Path = parsed.Path
+	}
+	return u
+}
+
+// sameURI compares the htu claim to the uri of the request ignoring the query and fragment
+func sameURI(htu interface{}, u *url.URL) bool {
+	s, ok := htu.(string)
+	if !ok {
+		return false
+	}
+	parsed, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	path := parsed.Path
+	if path == "" {
+		path = "/"
+	}
+	expected := u.Path
+	if expected == "" {
+		expected = "/"
+	}
+	return strings.EqualFold(parsed.Scheme, u.Scheme) && strings.EqualFold(parsed.Host, u.Host) && path == expected
+}
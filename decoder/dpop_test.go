@@ -0,0 +1,79 @@
+package decoder_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+const (
+	dpopMethod = "POST"
+	dpopURI    = "https://api.example.com/orders"
+)
+
+func TestDPoP(t *testing.T) {
+	tc := dt.NewTest()
+	jwsDec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string))
+	srv := decoder.NewServer(jwsDec, dt.AuthHeaderKey, dt.TokenValidatedHeaderKey, false, decoder.WithDPoP(time.Minute))
+	key, otherKey := dt.NewDPoPKey(), dt.NewDPoPKey()
+	bound := tc.NewValidToken(map[string]interface{}{"cnf": map[string]string{"jkt": key.Thumbprint()}})
+	unbound := tc.NewValidToken(map[string]interface{}{})
+	proofWith := func(claim string, value interface{}) []byte {
+		claims := dt.ProofClaims(dpopMethod, dpopURI, bound)
+		claims[claim] = value
+		return key.NewProof(claims)
+	}
+	replayed := key.NewProof(dt.ProofClaims(dpopMethod, dpopURI, bound))
+	// in order as the replayed proof has to be rejected after its first use
+	tests := []struct {
+		name  string
+		auth  string
+		token []byte
+		proof []byte
+		code  int
+	}{
+		{name: "Valid", auth: "DPoP", token: bound, proof: key.NewProof(dt.ProofClaims(dpopMethod, dpopURI, bound)), code: http.StatusOK},
+		{name: "LowerCaseScheme", auth: "dpop", token: bound, proof: key.NewProof(dt.ProofClaims(dpopMethod, dpopURI, bound)), code: http.StatusOK},
+		{name: "UriWithQuery", auth: "DPoP", token: bound, proof: proofWith("htu", dpopURI+"?id=1"), code: http.StatusOK},
+		{name: "FirstUse", auth: "DPoP", token: bound, proof: replayed, code: http.StatusOK},
+		{name: "Replayed", auth: "DPoP", token: bound, proof: replayed, code: http.StatusUnauthorized},
+		{name: "MissingProof", auth: "DPoP", token: bound, code: http.StatusUnauthorized},
+		{name: "WrongMethod", auth: "DPoP", token: bound, proof: proofWith("htm", "GET"), code: http.StatusUnauthorized},
+		{name: "WrongUri", auth: "DPoP", token: bound, proof: proofWith("htu", "https://api.example.com/other"), code: http.StatusUnauthorized},
+		{name: "WrongHost", auth: "DPoP", token: bound, proof: proofWith("htu", "https://evil.example.com/orders"), code: http.StatusUnauthorized},
+		{name: "OldProof", auth: "DPoP", token: bound, proof: proofWith("iat", time.Now().Add(-2*time.Minute).Unix()), code: http.StatusUnauthorized},
+		{name: "FutureProof", auth: "DPoP", token: bound, proof: proofWith("iat", time.Now().Add(time.Minute).Unix()), code: http.StatusUnauthorized},
+		{name: "WrongAccessTokenHash", auth: "DPoP", token: bound, proof: proofWith("ath", "invalid"), code: http.StatusUnauthorized},
+		{name: "MissingJti", auth: "DPoP", token: bound, proof: proofWith("jti", ""), code: http.StatusUnauthorized},
+		{name: "ProofOfOtherKey", auth: "DPoP", token: bound, proof: otherKey.NewProof(dt.ProofClaims(dpopMethod, dpopURI, bound)), code: http.StatusUnauthorized},
+		{name: "BoundTokenAsBearer", auth: "Bearer", token: bound, code: http.StatusUnauthorized},
+		{name: "UnboundTokenWithProof", auth: "DPoP", token: unbound, proof: key.NewProof(dt.ProofClaims(dpopMethod, dpopURI, unbound)), code: http.StatusUnauthorized},
+		{name: "UnboundTokenAsBearer", auth: "Bearer", token: unbound, code: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr, req := dpopReqFor(test.auth, test.token, test.proof)
+			srv.DecodeToken(rr, req)
+			status := rr.Result().StatusCode
+			dt.Report(t, status != test.code, "incorrect server response, %d, expected: %d", status, test.code)
+		})
+	}
+}
+
+func dpopReqFor(scheme string, token, proof []byte) (*httptest.ResponseRecorder, *http.Request) {
+	req, _ := http.NewRequestWithContext(dt.Ctx(), "GET", "/", nil)
+	req.Header.Add(dt.AuthHeaderKey, fmt.Sprintf("%s %s", scheme, token))
+	req.Header.Set("X-Forwarded-Method", dpopMethod)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "api.example.com")
+	req.Header.Set("X-Forwarded-Uri", "/orders?id=1")
+	if proof != nil {
+		req.Header.Set(decoder.DPoPHeader, string(proof))
+	}
+	return httptest.NewRecorder(), req
+}
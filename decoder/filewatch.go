@@ -0,0 +1,54 @@
+package decoder
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// fileWatcher loads a file again whenever its modification time changes
+type fileWatcher struct {
+	path    string
+	name    string
+	load    func(data []byte) error
+	mutex   sync.Mutex
+	modTime time.Time
+}
+
+// reload loads the file if it has been modified since the last load, the modification time is only
+// remembered if the file could be loaded so a broken file is retried on the next reload
+func (w *fileWatcher) reload() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(w.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+	if err := w.load(data); err != nil {
+		return err
+	}
+	w.modTime = info.ModTime()
+	return nil
+}
+
+// watch reloads the file every interval keeping the previous contents if it can't be loaded,
+// afterPoll (if not nil) is called after every poll
+func (w *fileWatcher) watch(interval time.Duration, afterPoll func()) {
+	for range time.Tick(interval) {
+		if err := w.reload(); err != nil {
+			log.Warn().Err(err).Str("path", w.path).Msgf("unable to reload %s, keeping the previous %s", w.name, w.name)
+		}
+		if afterPoll != nil {
+			afterPoll()
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ArrayFormat defines how array claims are rendered in their header
+type ArrayFormat string
+
+// ObjectFormat defines how object claims are rendered in their header
+type ObjectFormat string
+
+const (
+	// JSONArray renders arrays as json (the default)
+	JSONArray ArrayFormat = "json"
+	// JoinedArray renders arrays as their elements joined with the delimiter
+	JoinedArray ArrayFormat = "join"
+	// RepeatedArray renders arrays as one header value per element
+	RepeatedArray ArrayFormat = "repeat"
+
+	// JSONObject renders objects as json (the default)
+	JSONObject ObjectFormat = "json"
+	// FlattenedObject renders objects as key=value pairs sorted by key, which are joined with the delimiter
+	// or repeated like arrays
+	FlattenedObject ObjectFormat = "flatten"
+
+	// DefaultDelimiter joins the elements of arrays and flattened objects if the format has no delimiter
+	DefaultDelimiter = ","
+)
+
+// ClaimFormat defines how the array and object claims of a header are rendered,
+// elements that are not strings are json encoded
+type ClaimFormat struct {
+	Array     ArrayFormat
+	Object    ObjectFormat
+	Delimiter string
+}
+
+// UnknownClaimFormatError is thrown if a claim format is not supported
+type UnknownClaimFormatError struct {
+	format string
+}
+
+func (e UnknownClaimFormatError) Error() string {
+	return fmt.Sprintf("unknown claim format '%s'", e.format)
+}
+
+// Validate returns an error if the array or object format of the claim format is not supported
+func (f ClaimFormat) Validate() error {
+	switch f.Array {
+	case "", JSONArray, JoinedArray, RepeatedArray:
+	default:
+		return UnknownClaimFormatError{string(f.Array)}
+	}
+	switch f.Object {
+	case "", JSONObject, FlattenedObject:
+	default:
+		return UnknownClaimFormatError{string(f.Object)}
+	}
+	return nil
+}
+
+// WithClaimFormats renders the claims of the headers with their format instead of as json
+func WithClaimFormats(formats map[string]ClaimFormat) ServerOption {
+	return func(s *Server) {
+		s.claimFormats = formats
+	}
+}
+
+// WithMappedClaimFormats renders the claims mapped by the decoder with their format by header instead of
+// the formats of the server, e.g. for an issuer with its own claim mappings
+func WithMappedClaimFormats(formats map[string]ClaimFormat) JwsDecoderOption {
+	return func(d *jwsDecoder) {
+		d.formats = formats
+	}
+}
+
+// render returns the header values of the claim and false if it is rendered as json
+func (f ClaimFormat) render(value interface{}) ([]string, bool) {
+	var elements []string
+	switch v := value.(type) {
+	case []interface{}:
+		if f.Array == "" || f.Array == JSONArray {
+			return nil, false
+		}
+		for _, element := range v {
+			elements = append(elements, claimString(element))
+		}
+	case []string:
+		if f.Array == "" || f.Array == JSONArray {
+			return nil, false
+		}
+		elements = v
+	case map[string]interface{}:
+		if f.Object != FlattenedObject {
+			return nil, false
+		}
+		for key, element := range v {
+			elements = append(elements, key+"="+claimString(element))
+		}
+		sort.Strings(elements)
+	default:
+		return nil, false
+	}
+	if f.Array == RepeatedArray {
+		return elements, true
+	}
+	delimiter := f.Delimiter
+	if delimiter == "" {
+		delimiter = DefaultDelimiter
+	}
+	return []string{strings.Join(elements, delimiter)}, true
+}
+
+// claimString returns string claims as is and all other claims json encoded
+func claimString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
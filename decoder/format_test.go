@@ -0,0 +1,87 @@
+package decoder_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func TestClaimFormats(t *testing.T) {
+	tc := dt.NewTest()
+	claims := map[string]interface{}{
+		"groups": []string{"admin", "dev"},
+		"ids":    []interface{}{1, map[string]string{"a": "b"}},
+		"attrs":  map[string]interface{}{"team": "core", "level": 3},
+		"name":   "alice",
+	}
+	tests := map[string]struct {
+		claim    string
+		format   decoder.ClaimFormat
+		expected []string
+	}{
+		"NoFormat":          {claim: "groups", expected: []string{`["admin","dev"]`}},
+		"JSONArray":         {claim: "groups", format: decoder.ClaimFormat{Array: decoder.JSONArray}, expected: []string{`["admin","dev"]`}},
+		"JoinedArray":       {claim: "groups", format: decoder.ClaimFormat{Array: decoder.JoinedArray}, expected: []string{"admin,dev"}},
+		"JoinedDelimiter":   {claim: "groups", format: decoder.ClaimFormat{Array: decoder.JoinedArray, Delimiter: "; "}, expected: []string{"admin; dev"}},
+		"RepeatedArray":     {claim: "groups", format: decoder.ClaimFormat{Array: decoder.RepeatedArray}, expected: []string{"admin", "dev"}},
+		"NonStringElements": {claim: "ids", format: decoder.ClaimFormat{Array: decoder.JoinedArray, Delimiter: " "}, expected: []string{`1 {"a":"b"}`}},
+		"JSONObject":        {claim: "attrs", format: decoder.ClaimFormat{Array: decoder.JoinedArray}, expected: []string{`{"level":3,"team":"core"}`}},
+		"FlattenedObject":   {claim: "attrs", format: decoder.ClaimFormat{Object: decoder.FlattenedObject}, expected: []string{"level=3,team=core"}},
+		"RepeatedObject":    {claim: "attrs", format: decoder.ClaimFormat{Object: decoder.FlattenedObject, Array: decoder.RepeatedArray}, expected: []string{"level=3", "team=core"}},
+		"String":            {claim: "name", format: decoder.ClaimFormat{Array: decoder.RepeatedArray, Object: decoder.FlattenedObject}, expected: []string{"alice"}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			jwsDec, _ := decoder.NewJwsDecoder(tc.JwksURL, map[string]string{test.claim: "jwt-token-claim"})
+			srv := decoder.NewServer(jwsDec, dt.AuthHeaderKey, dt.TokenValidatedHeaderKey, false,
+				decoder.WithClaimFormats(map[string]decoder.ClaimFormat{"jwt-token-claim": test.format}))
+			req, _ := http.NewRequestWithContext(dt.Ctx(), "GET", "/", nil)
+			req.Header.Add(dt.AuthHeaderKey, fmt.Sprintf("Bearer %s", tc.NewValidToken(claims)))
+			rr := httptest.NewRecorder()
+			srv.DecodeToken(rr, req)
+			values := rr.Result().Header.Values("jwt-token-claim")
+			dt.Report(t, strings.Join(values, "|") != strings.Join(test.expected, "|"), "header values are %q, expected: %q", values, test.expected)
+		})
+	}
+}
+
+func TestMappedClaimFormats(t *testing.T) {
+	tc := dt.NewTest()
+	join := map[string]decoder.ClaimFormat{"jwt-token-groups": {Array: decoder.JoinedArray}}
+	repeat := map[string]decoder.ClaimFormat{"jwt-token-groups": {Array: decoder.RepeatedArray}}
+	tests := map[string]struct {
+		opts     []decoder.JwsDecoderOption
+		expected []string
+	}{
+		"ServerFormat":  {expected: []string{"admin,dev"}},
+		"DecoderFormat": {opts: []decoder.JwsDecoderOption{decoder.WithMappedClaimFormats(repeat)}, expected: []string{"admin", "dev"}},
+		"NoDecoderFormat": {opts: []decoder.JwsDecoderOption{decoder.WithMappedClaimFormats(map[string]decoder.ClaimFormat{})},
+			expected: []string{`["admin","dev"]`}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			jwsDec, _ := decoder.NewJwsDecoder(tc.JwksURL, map[string]string{"groups": "jwt-token-groups"}, test.opts...)
+			srv := decoder.NewServer(jwsDec, dt.AuthHeaderKey, dt.TokenValidatedHeaderKey, false, decoder.WithClaimFormats(join))
+			req, _ := http.NewRequestWithContext(dt.Ctx(), "GET", "/", nil)
+			req.Header.Add(dt.AuthHeaderKey, fmt.Sprintf("Bearer %s", tc.NewValidToken(map[string]interface{}{"groups": []string{"admin", "dev"}})))
+			rr := httptest.NewRecorder()
+			srv.DecodeToken(rr, req)
+			values := rr.Result().Header.Values("jwt-token-groups")
+			dt.Report(t, strings.Join(values, "|") != strings.Join(test.expected, "|"), "header values are %q, expected: %q", values, test.expected)
+		})
+	}
+}
+
+func TestClaimFormatValidation(t *testing.T) {
+	err := decoder.ClaimFormat{Array: decoder.RepeatedArray, Object: decoder.FlattenedObject}.Validate()
+	dt.Report(t, err != nil, "expected valid claim format got %v", err)
+	err = decoder.ClaimFormat{Array: "csv"}.Validate()
+	var unknownClaimFormatErr decoder.UnknownClaimFormatError
+	dt.Report(t, !errors.As(err, &unknownClaimFormatErr), "expected UnknownClaimFormatError got %v", err)
+}
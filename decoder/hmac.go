@@ -0,0 +1,82 @@
+package decoder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+)
+
+// minHmacSecretLength returns the minimum length in bytes of the secret for the HMAC algorithm,
+// which is the size of the hash output (RFC 7518 section 3.2)
+func minHmacSecretLength(alg jwa.SignatureAlgorithm) int {
+	switch alg {
+	case jwa.HS384:
+		return 48
+	case jwa.HS512:
+		return 64
+	}
+	return 32
+}
+
+// NewHmacKeySource returns a KeySource with the HMAC secrets by their kid, secrets have to be at least 32 bytes long
+// and are only used for HS384 and HS512 if they are at least 48 and 64 bytes long
+func NewHmacKeySource(secrets map[string][]byte) (KeySource, error) {
+	keys := jwk.NewSet()
+	for kid, secret := range secrets {
+		if minLength := minHmacSecretLength(jwa.HS256); len(secret) < minLength {
+			return nil, fmt.Errorf("hmac secret '%s' has %d bytes, at least %d are required", kid, len(secret), minLength)
+		}
+		key, err := jwk.New(secret)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create hmac key '%s': %w", kid, err)
+		}
+		key.Set(jwk.KeyIDKey, kid)
+		key.Set(jwk.KeyUsageKey, jwk.ForSignature)
+		keys.Add(key)
+	}
+	return NewStaticKeySource(keys), nil
+}
+
+// NewHmacDecoder returns a Decoder like NewKeySourceDecoder that only verifies HMAC (HS256, HS384, HS512)
+// signed tokens with the symmetric keys of the KeySource, the secret is selected by the kid of the token.
+// All other decoders never accept HMAC signed tokens or use symmetric keys
+func NewHmacDecoder(keys KeySource, claimMapping map[string]string, opts ...JwsDecoderOption) TokenDecoder {
+	d := jwsDecoder{claimMapping: claimMapping, keys: keys, symmetric: true}
+	for _, opt := range append([]JwsDecoderOption{WithAlgorithms(jwa.HS256, jwa.HS384, jwa.HS512)}, opts...) {
+		opt(&d)
+	}
+	return &d
+}
+
+type hmacSplitDecoder struct {
+	hmac       TokenDecoder
+	asymmetric TokenDecoder
+}
+
+// NewHmacSplitDecoder returns a TokenDecoder that decodes HMAC signed tokens with the hmac decoder
+// and all other tokens with the asymmetric decoder, which may be nil if only HMAC tokens are accepted
+func NewHmacSplitDecoder(hmac, asymmetric TokenDecoder) TokenDecoder {
+	return &hmacSplitDecoder{hmac: hmac, asymmetric: asymmetric}
+}
+
+func (d *hmacSplitDecoder) Decode(ctx context.Context, raw string) (*Token, error) {
+	msg, err := jws.ParseString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse token: %w", err)
+	}
+	if len(msg.Signatures()) != 1 {
+		return nil, fmt.Errorf("token has %d signatures, expected 1", len(msg.Signatures()))
+	}
+	alg := msg.Signatures()[0].ProtectedHeaders().Algorithm()
+	if isHmac(alg) {
+		return d.hmac.Decode(ctx, raw)
+	}
+	if d.asymmetric == nil {
+		rejectedAlgorithms.WithLabelValues(alg.String()).Inc()
+		return nil, UnsupportedAlgorithmError{alg}
+	}
+	return d.asymmetric.Decode(ctx, raw)
+}
@@ -0,0 +1,64 @@
+package decoder_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+	"github.com/lestrrat-go/jwx/jwa"
+)
+
+func TestHmacSplitDecoder(t *testing.T) {
+	tc := dt.NewTest()
+	secrets := map[string][]byte{"old": []byte("old-secret-old-secret-old-secret"), "new": []byte(strings.Repeat("new-secret", 7))}
+	keys, err := decoder.NewHmacKeySource(secrets)
+	dt.HandleByPanic(err)
+	hmacDec := decoder.NewHmacDecoder(keys, make(map[string]string))
+	asymmetricDec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string))
+	dec := decoder.NewHmacSplitDecoder(hmacDec, asymmetricDec)
+	tests := map[string]struct {
+		token []byte
+		valid bool
+	}{
+		"RsaToken":          {token: tc.NewValidToken(map[string]interface{}{}), valid: true},
+		"OldSecret":         {token: dt.NewHmacToken(map[string]interface{}{}, jwa.HS256, "old", secrets["old"]), valid: true},
+		"NewSecret":         {token: dt.NewHmacToken(map[string]interface{}{}, jwa.HS512, "new", secrets["new"]), valid: true},
+		"ShortSecretHS512":  {token: dt.NewHmacToken(map[string]interface{}{}, jwa.HS512, "old", secrets["old"])},
+		"SecretOfOtherKid":  {token: dt.NewHmacToken(map[string]interface{}{}, jwa.HS256, "new", secrets["old"])},
+		"UnknownSecret":     {token: dt.NewHmacToken(map[string]interface{}{}, jwa.HS256, "old", []byte("unknown"))},
+		"PublicKeyAsSecret": {token: dt.NewHmacToken(map[string]interface{}{}, jwa.HS256, "old", tc.PublicKeyPEM())},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := dec.Decode(dt.Ctx(), string(test.token))
+			dt.Report(t, test.valid && err != nil, "expected token to be valid got %v", err)
+			dt.Report(t, !test.valid && err == nil, "expected token to be rejected")
+		})
+	}
+}
+
+func TestHmacOnlySplitDecoderRejectsAsymmetricTokens(t *testing.T) {
+	tc := dt.NewTest()
+	keys, err := decoder.NewHmacKeySource(map[string][]byte{"kid": []byte(strings.Repeat("secret", 6))})
+	dt.HandleByPanic(err)
+	dec := decoder.NewHmacSplitDecoder(decoder.NewHmacDecoder(keys, make(map[string]string)), nil)
+	_, err = dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{})))
+	var unsupportedAlgorithmErr decoder.UnsupportedAlgorithmError
+	dt.Report(t, !errors.As(err, &unsupportedAlgorithmErr), "expected UnsupportedAlgorithmError got %v", err)
+}
+
+func TestHmacDecoderRejectsAsymmetricTokens(t *testing.T) {
+	tc := dt.NewTest()
+	keys, err := decoder.NewHmacKeySource(map[string][]byte{"kid": []byte(strings.Repeat("secret", 6))})
+	dt.HandleByPanic(err)
+	_, err = decoder.NewHmacDecoder(keys, make(map[string]string)).Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{})))
+	var unsupportedAlgorithmErr decoder.UnsupportedAlgorithmError
+	dt.Report(t, !errors.As(err, &unsupportedAlgorithmErr), "expected UnsupportedAlgorithmError got %v", err)
+}
+
+func TestHmacKeySourceRejectsShortSecrets(t *testing.T) {
+	_, err := decoder.NewHmacKeySource(map[string][]byte{"kid": []byte(strings.Repeat("s", 31))})
+	dt.Report(t, err == nil, "expected secret shorter than 32 bytes to be rejected")
+}
@@ -0,0 +1,158 @@
+package decoder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// maxIntrospectionResponseSize is the maximum size of an introspection response that is read
+	maxIntrospectionResponseSize = 1 << 20
+	// introspectionCacheTTL is the longest time the result of an introspection without exp is cached
+	// so a token revoked at the authorization server is rejected soon after
+	introspectionCacheTTL = 30 * time.Second
+)
+
+// InactiveTokenError is thrown if the introspection endpoint reports the token as not active
+type InactiveTokenError struct{}
+
+func (e InactiveTokenError) Error() string {
+	return "token is not active"
+}
+
+// IntrospectionError is thrown if the introspection endpoint is unreachable or does not respond with a valid
+// introspection response, it is temporary so the token is introspected again on the next request
+type IntrospectionError struct {
+	err error
+}
+
+func (e IntrospectionError) Error() string {
+	return fmt.Sprintf("unable to introspect token: %s", e.err)
+}
+
+func (e IntrospectionError) Unwrap() error {
+	return e.err
+}
+
+// Temporary returns true as the endpoint might respond on the next request
+func (e IntrospectionError) Temporary() bool {
+	return true
+}
+
+type introspectionDecoder struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	claimMapping map[string]string
+	client       *http.Client
+	policy       claimPolicy
+}
+
+// NewIntrospectionDecoder returns a TokenDecoder that resolves (opaque) tokens with the OAuth 2.0 token
+// introspection endpoint (RFC 7662) authenticating with the client credentials, the claims of the
+// introspection response are mapped like the claims of a JWT. The endpoint is called with the client
+// or the default http client if it is nil and the claims are validated with the policy like for JWTs.
+// Results without exp are cached for at most 30 seconds
+func NewIntrospectionDecoder(endpoint, clientID, clientSecret string, claimMapping map[string]string, client *http.Client, policy ClaimPolicy) TokenDecoder {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &introspectionDecoder{endpoint: endpoint, clientID: clientID, clientSecret: clientSecret,
+		claimMapping: claimMapping, client: client, policy: newClaimPolicy(policy)}
+}
+
+func (d *introspectionDecoder) Decode(ctx context.Context, raw string) (*Token, error) {
+	form := url.Values{"token": {raw}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(d.clientID), url.QueryEscape(d.clientSecret))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, IntrospectionError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, IntrospectionError{fmt.Errorf("unexpected status %d", resp.StatusCode)}
+	}
+	var claims map[string]interface{}
+	dec := json.NewDecoder(io.LimitReader(resp.Body, maxIntrospectionResponseSize))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, IntrospectionError{fmt.Errorf("unable to parse response: %w", err)}
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, InactiveTokenError{}
+	}
+	get := func(key string) (interface{}, bool) {
+		value, ok := claims[key]
+		return value, ok
+	}
+	iss, _ := claims["iss"].(string)
+	if err = d.policy.validate(iss, audience(claims["aud"]), get); err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	token := &Token{
+		ID:                    jti,
+		Subject:               sub,
+		Expiration:            numericDate(claims["exp"]),
+		NotBefore:             numericDate(claims["nbf"]),
+		IssuedAt:              numericDate(claims["iat"]),
+		AuthTime:              numericDate(claims["auth_time"]),
+		KeyThumbprint:         confirmation(claims["cnf"], "jkt"),
+		CertificateThumbprint: confirmation(claims["cnf"], "x5t#S256"),
+	}
+	if token.Expiration.IsZero() {
+		token.maxCacheTTL = introspectionCacheTTL
+	}
+	if token.Claims, token.values, err = mapClaims(d.claimMapping, get); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// audience returns the audiences of the aud claim which is a single string or an array of strings
+func audience(aud interface{}) []string {
+	switch v := aud.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		audiences := make([]string, 0, len(v))
+		for _, element := range v {
+			if s, ok := element.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+		return audiences
+	}
+	return nil
+}
+
+type opaqueSplitDecoder struct {
+	jwt    TokenDecoder
+	opaque TokenDecoder
+}
+
+// NewOpaqueSplitDecoder returns a TokenDecoder that decodes JWTs (three part signed or five part encrypted tokens)
+// with the jwt decoder and all other tokens with the opaque decoder
+func NewOpaqueSplitDecoder(jwt, opaque TokenDecoder) TokenDecoder {
+	return &opaqueSplitDecoder{jwt: jwt, opaque: opaque}
+}
+
+func (d *opaqueSplitDecoder) Decode(ctx context.Context, raw string) (*Token, error) {
+	if parts := strings.Count(raw, ".") + 1; parts == 3 || parts == 5 {
+		return d.jwt.Decode(ctx, raw)
+	}
+	return d.opaque.Decode(ctx, raw)
+}
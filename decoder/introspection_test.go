@@ -0,0 +1,119 @@
+package decoder_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func startIntrospectionServer(responses map[string]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		resp, ok := responses[r.PostFormValue("token")]
+		if !ok {
+			resp = map[string]interface{}{"active": false}
+		}
+		json.NewEncoder(rw).Encode(resp)
+	}))
+}
+
+func TestIntrospectionDecoder(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	server := startIntrospectionServer(map[string]map[string]interface{}{
+		"active":   {"active": true, "sub": "alice", "exp": exp, "scope": "read write", "roles": []string{"admin"}},
+		"inactive": {"active": false, "sub": "bob"},
+	})
+	defer server.Close()
+	dec := decoder.NewIntrospectionDecoder(server.URL, "client", "secret", map[string]string{"scope": "jwt-token-scope", "roles": "jwt-token-roles"}, nil, decoder.ClaimPolicy{})
+
+	token, err := dec.Decode(dt.Ctx(), "active")
+	dt.Report(t, err != nil, "unable to introspect active token %s", err)
+	dt.Report(t, err == nil && token.Claims["jwt-token-scope"] != "read write", "incorrect claims %v", token)
+	dt.Report(t, err == nil && token.Claims["jwt-token-roles"] != `["admin"]`, "incorrect claims %v", token)
+	dt.Report(t, err == nil && (token.Subject != "alice" || token.Expiration.Unix() != exp), "incorrect sub or exp %v", token)
+
+	for _, raw := range []string{"inactive", "unknown"} {
+		_, err = dec.Decode(dt.Ctx(), raw)
+		var inactiveErr decoder.InactiveTokenError
+		dt.Report(t, !errors.As(err, &inactiveErr), "expected InactiveTokenError for %s got %v", raw, err)
+	}
+
+	_, err = decoder.NewIntrospectionDecoder(server.URL, "client", "wrong", make(map[string]string), nil, decoder.ClaimPolicy{}).Decode(dt.Ctx(), "active")
+	dt.Report(t, err == nil, "expected error with invalid client credentials")
+}
+
+func TestIntrospectionPolicies(t *testing.T) {
+	authTime := time.Now().Add(-time.Minute).Unix()
+	server := startIntrospectionServer(map[string]map[string]interface{}{
+		"valid":        {"active": true, "iss": "https://issuer", "aud": []string{"api", "other"}, "email": "alice@example.com", "auth_time": authTime},
+		"wrongIssuer":  {"active": true, "iss": "https://other", "aud": "api", "email": "alice@example.com"},
+		"wrongAud":     {"active": true, "iss": "https://issuer", "aud": "other", "email": "alice@example.com"},
+		"missingClaim": {"active": true, "iss": "https://issuer", "aud": "api"},
+	})
+	defer server.Close()
+	dec := decoder.NewIntrospectionDecoder(server.URL, "client", "secret", make(map[string]string), nil, decoder.ClaimPolicy{
+		Issuers:        []string{"https://issuer"},
+		Audiences:      []string{"api"},
+		AudienceMatch:  decoder.AnyAudience,
+		RequiredClaims: []string{"email"},
+	})
+
+	token, err := dec.Decode(dt.Ctx(), "valid")
+	dt.Report(t, err != nil, "expected valid token got %v", err)
+	dt.Report(t, err == nil && token.AuthTime.Unix() != authTime, "expected auth_time %d got %v", authTime, token)
+
+	var issErr decoder.InvalidIssuerError
+	_, err = dec.Decode(dt.Ctx(), "wrongIssuer")
+	dt.Report(t, !errors.As(err, &issErr), "expected InvalidIssuerError got %v", err)
+	var audErr decoder.InvalidAudienceError
+	_, err = dec.Decode(dt.Ctx(), "wrongAud")
+	dt.Report(t, !errors.As(err, &audErr), "expected InvalidAudienceError got %v", err)
+	var claimErr decoder.MissingClaimError
+	_, err = dec.Decode(dt.Ctx(), "missingClaim")
+	dt.Report(t, !errors.As(err, &claimErr), "expected MissingClaimError got %v", err)
+}
+
+func TestIntrospectionResponseSizeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(map[string]interface{}{"active": true, "padding": strings.Repeat("a", 2<<20)})
+	}))
+	defer server.Close()
+	dec := decoder.NewIntrospectionDecoder(server.URL, "client", "secret", make(map[string]string), nil, decoder.ClaimPolicy{})
+	_, err := dec.Decode(dt.Ctx(), "large")
+	var introspectionErr decoder.IntrospectionError
+	dt.Report(t, !errors.As(err, &introspectionErr), "expected IntrospectionError for oversized response got %v", err)
+}
+
+func TestOpaqueSplitDecoder(t *testing.T) {
+	tc := dt.NewTest()
+	server := startIntrospectionServer(map[string]map[string]interface{}{"opaque": {"active": true}})
+	defer server.Close()
+	jwsDec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string))
+	dec := decoder.NewOpaqueSplitDecoder(jwsDec, decoder.NewIntrospectionDecoder(server.URL, "client", "secret", make(map[string]string), nil, decoder.ClaimPolicy{}))
+	tests := map[string]struct {
+		token string
+		valid bool
+	}{
+		"Jwt":           {token: string(tc.NewValidToken(map[string]interface{}{})), valid: true},
+		"InvalidJwt":    {token: string(tc.NewInvalidToken(map[string]interface{}{}))},
+		"Opaque":        {token: "opaque", valid: true},
+		"UnknownOpaque": {token: "unknown"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := dec.Decode(dt.Ctx(), test.token)
+			dt.Report(t, test.valid && err != nil, "expected token to be valid got %v", err)
+			dt.Report(t, !test.valid && err == nil, "expected token to be rejected")
+		})
+	}
+}
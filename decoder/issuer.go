@@ -0,0 +1,30 @@
+package decoder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+type issuerDecoder struct {
+	decoders map[string]TokenDecoder
+}
+
+// NewIssuerDecoder returns a TokenDecoder that delegates to the decoder of the (unverified) `iss` claim of the token,
+// tokens from issuers without a decoder are rejected without being verified
+func NewIssuerDecoder(decoders map[string]TokenDecoder) TokenDecoder {
+	return &issuerDecoder{decoders: decoders}
+}
+
+func (d *issuerDecoder) Decode(ctx context.Context, raw string) (*Token, error) {
+	unverified, err := jwt.ParseString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse token: %w", err)
+	}
+	dec, ok := d.decoders[unverified.Issuer()]
+	if !ok {
+		return nil, InvalidIssuerError{unverified.Issuer()}
+	}
+	return dec.Decode(ctx, raw)
+}
@@ -0,0 +1,34 @@
+package decoder_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func TestIssuerDecoder(t *testing.T) {
+	staff, customers := dt.NewTest(), dt.NewTest()
+	staffDec, _ := decoder.NewJwsDecoder(staff.JwksURL, map[string]string{"email": "jwt-token-email"}, decoder.WithIssuers("https://staff"))
+	customerDec, _ := decoder.NewJwsDecoder(customers.JwksURL, map[string]string{"email": "jwt-token-customer"}, decoder.WithIssuers("https://customers"))
+	dec := decoder.NewIssuerDecoder(map[string]decoder.TokenDecoder{
+		"https://staff":     staffDec,
+		"https://customers": customerDec,
+	})
+
+	token, err := dec.Decode(dt.Ctx(), string(staff.NewValidToken(map[string]interface{}{"iss": "https://staff", "email": "a@b.c"})))
+	dt.Report(t, err != nil, "unable to decode staff token %s", err)
+	dt.Report(t, token.Claims["jwt-token-email"] != "a@b.c", "incorrect claims for staff token %v", token.Claims)
+
+	token, err = dec.Decode(dt.Ctx(), string(customers.NewValidToken(map[string]interface{}{"iss": "https://customers", "email": "a@b.c"})))
+	dt.Report(t, err != nil, "unable to decode customer token %s", err)
+	dt.Report(t, token.Claims["jwt-token-customer"] != "a@b.c", "incorrect claims for customer token %v", token.Claims)
+
+	_, err = dec.Decode(dt.Ctx(), string(customers.NewValidToken(map[string]interface{}{"iss": "https://staff"})))
+	dt.Report(t, err == nil, "token signed by the customer issuer accepted as staff token")
+
+	_, err = dec.Decode(dt.Ctx(), string(staff.NewValidToken(map[string]interface{}{"iss": "https://other"})))
+	var issErr decoder.InvalidIssuerError
+	dt.Report(t, !errors.As(err, &issErr), "expected InvalidIssuerError got %v", err)
+}
@@ -0,0 +1,95 @@
+package decoder
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwe"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// UnsupportedKeyEncryptionError is thrown if the alg header of an encrypted token can not be used with the decryption key
+type UnsupportedKeyEncryptionError struct {
+	alg jwa.KeyEncryptionAlgorithm
+}
+
+func (e UnsupportedKeyEncryptionError) Error() string {
+	return fmt.Sprintf("token key encryption algorithm '%s' is not allowed", e.alg)
+}
+
+// DecryptionError is thrown if an encrypted token can not be decrypted with the decryption key
+type DecryptionError struct {
+	err error
+}
+
+func (e DecryptionError) Error() string {
+	return fmt.Sprintf("unable to decrypt token: %s", e.err)
+}
+
+func (e DecryptionError) Unwrap() error {
+	return e.err
+}
+
+type jweDecoder struct {
+	key        interface{}
+	algorithms map[jwa.KeyEncryptionAlgorithm]bool
+	delegate   TokenDecoder
+}
+
+// ParseDecryptionKey parses a private RSA or EC key from a JWK or PEM encoded private key
+func ParseDecryptionKey(data []byte) (jwk.Key, error) {
+	key, err := jwk.ParseKey(data)
+	if err != nil {
+		if key, err = jwk.ParseKey(data, jwk.WithPEM(true)); err != nil {
+			return nil, fmt.Errorf("unable to parse decryption key: %w", err)
+		}
+	}
+	return key, nil
+}
+
+// NewJweDecoder returns a TokenDecoder that decrypts encrypted tokens (JWE compact serialization) with the private key
+// and decodes the nested signed token with the delegate, unencrypted tokens are passed to the delegate as is.
+// RSA keys decrypt RSA-OAEP and RSA-OAEP-256, EC keys ECDH-ES and ECDH-ES with AES key wrap
+func NewJweDecoder(key jwk.Key, delegate TokenDecoder) (TokenDecoder, error) {
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return nil, fmt.Errorf("unable to use decryption key: %w", err)
+	}
+	d := jweDecoder{key: raw, delegate: delegate}
+	switch raw.(type) {
+	case *rsa.PrivateKey:
+		d.algorithms = map[jwa.KeyEncryptionAlgorithm]bool{jwa.RSA_OAEP: true, jwa.RSA_OAEP_256: true}
+	case *ecdsa.PrivateKey:
+		d.algorithms = map[jwa.KeyEncryptionAlgorithm]bool{jwa.ECDH_ES: true, jwa.ECDH_ES_A128KW: true,
+			jwa.ECDH_ES_A192KW: true, jwa.ECDH_ES_A256KW: true}
+	default:
+		return nil, fmt.Errorf("decryption key has to be a private RSA or EC key, got %T", raw)
+	}
+	return &d, nil
+}
+
+func (d *jweDecoder) Decode(ctx context.Context, raw string) (*Token, error) {
+	if strings.Count(raw, ".") != 4 {
+		return d.delegate.Decode(ctx, raw)
+	}
+	msg, err := jwe.ParseString(raw)
+	if err != nil {
+		decryptionFailures.WithLabelValues("").Inc()
+		return nil, DecryptionError{err}
+	}
+	alg := msg.ProtectedHeaders().Algorithm()
+	if !d.algorithms[alg] {
+		decryptionFailures.WithLabelValues(alg.String()).Inc()
+		return nil, UnsupportedKeyEncryptionError{alg}
+	}
+	payload, err := jwe.Decrypt([]byte(raw), alg, d.key)
+	if err != nil {
+		decryptionFailures.WithLabelValues(alg.String()).Inc()
+		return nil, DecryptionError{err}
+	}
+	return d.delegate.Decode(ctx, string(payload))
+}
@@ -0,0 +1,78 @@
+package decoder_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func TestJweDecoder(t *testing.T) {
+	tc := dt.NewTest()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwsDec, _ := decoder.NewJwsDecoder(tc.JwksURL, map[string]string{"email": "email"})
+	newJweDecoder := func(raw interface{}) decoder.TokenDecoder {
+		key, err := jwk.New(raw)
+		dt.HandleByPanic(err)
+		dec, err := decoder.NewJweDecoder(key, jwsDec)
+		dt.HandleByPanic(err)
+		return dec
+	}
+	rsaDec, ecDec := newJweDecoder(rsaKey), newJweDecoder(ecKey)
+	claims := map[string]interface{}{"email": "info@example.com"}
+	tests := map[string]struct {
+		dec   decoder.TokenDecoder
+		token []byte
+		valid bool
+		err   interface{}
+	}{
+		"RsaOaep":            {dec: rsaDec, token: dt.Encrypt(tc.NewValidToken(claims), jwa.RSA_OAEP, &rsaKey.PublicKey), valid: true},
+		"RsaOaep256":         {dec: rsaDec, token: dt.Encrypt(tc.NewValidToken(claims), jwa.RSA_OAEP_256, &rsaKey.PublicKey), valid: true},
+		"EcdhEs":             {dec: ecDec, token: dt.Encrypt(tc.NewValidToken(claims), jwa.ECDH_ES, &ecKey.PublicKey), valid: true},
+		"EcdhEsKeyWrap":      {dec: ecDec, token: dt.Encrypt(tc.NewValidToken(claims), jwa.ECDH_ES_A256KW, &ecKey.PublicKey), valid: true},
+		"Unencrypted":        {dec: rsaDec, token: tc.NewValidToken(claims), valid: true},
+		"RsaPkcs1":           {dec: rsaDec, token: dt.Encrypt(tc.NewValidToken(claims), jwa.RSA1_5, &rsaKey.PublicKey), err: new(decoder.UnsupportedKeyEncryptionError)},
+		"EcdhEsWithRsaKey":   {dec: rsaDec, token: dt.Encrypt(tc.NewValidToken(claims), jwa.ECDH_ES, &ecKey.PublicKey), err: new(decoder.UnsupportedKeyEncryptionError)},
+		"OtherKey":           {dec: rsaDec, token: dt.Encrypt(tc.NewValidToken(claims), jwa.RSA_OAEP, &otherKey.PublicKey), err: new(decoder.DecryptionError)},
+		"MalformedEncrypted": {dec: rsaDec, token: []byte("a.b.c.d.e"), err: new(decoder.DecryptionError)},
+		"InvalidInnerToken":  {dec: rsaDec, token: dt.Encrypt(tc.NewInvalidToken(claims), jwa.RSA_OAEP, &rsaKey.PublicKey)},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			token, err := test.dec.Decode(dt.Ctx(), string(test.token))
+			if test.valid {
+				dt.Report(t, err != nil, "expected token to be valid got %v", err)
+				dt.Report(t, err == nil && token.Claims["email"] != "info@example.com", "expected email claim to be mapped got %v", token)
+				return
+			}
+			dt.Report(t, err == nil, "expected token to be rejected")
+			if test.err != nil {
+				dt.Report(t, !errors.As(err, test.err), "expected %T got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestParseDecryptionKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	key, err := decoder.ParseDecryptionKey(pemKey)
+	dt.Report(t, err != nil, "expected PEM key to be parsed got %v", err)
+	_, err = decoder.NewJweDecoder(key, nil)
+	dt.Report(t, err != nil, "expected PEM key to be usable got %v", err)
+
+	public, _ := jwk.New(&rsaKey.PublicKey)
+	_, err = decoder.NewJweDecoder(public, nil)
+	dt.Report(t, err == nil, "expected public key to be rejected")
+}
@@ -5,18 +5,41 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jwk"
-	"github.com/lestrrat-go/jwx/jws"
 	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/rs/zerolog/log"
 )
 
 type jwsDecoder struct {
 	jwks         *jwk.Set
 	claimMapping map[string]string
-	jwksURL      string
-	jwksFetcher  *jwk.AutoRefresh
+	keys         KeySource
 	mutex        sync.RWMutex
+	policy       claimPolicy
+	algorithms   map[jwa.SignatureAlgorithm]bool
+	tokenType    string
+	symmetric    bool
+
+	refreshInterval time.Duration
+	refreshMutex    sync.Mutex
+	lastRefresh     time.Time
+	remoteOpts      []RemoteKeySourceOption
+	formats         map[string]ClaimFormat
+}
+
+// JwsDecoderOption configures optional validations of the JWS decoder
+type JwsDecoderOption func(d *jwsDecoder)
+
+// WithIssuers restricts the accepted tokens to tokens with one of the given `iss` claims,
+// if no issuers are given all issuers are accepted.
+// If given multiple times only the issuers allowed by all of them are accepted
+func WithIssuers(issuers ...string) JwsDecoderOption {
+	return func(d *jwsDecoder) {
+		d.policy.allowIssuers(issuers)
+	}
 }
 
 // UnexpectedClaimTypeError is thrown if a mapped claim in the token has an unexpected type
@@ -30,53 +53,179 @@ func (e UnexpectedClaimTypeError) Error() string {
 	return fmt.Sprintf("claim %s has type %T not string", e.name, e.claim)
 }
 
+// MissingClaimError is thrown if a required claim is not present in the token
+type MissingClaimError struct {
+	name string
+}
+
+func (e MissingClaimError) Error() string {
+	return fmt.Sprintf("required claim '%s' is missing", e.name)
+}
+
+// InvalidIssuerError is thrown if the issuer of the token is not one of the allowed issuers
+type InvalidIssuerError struct {
+	issuer string
+}
+
+func (e InvalidIssuerError) Error() string {
+	return fmt.Sprintf("token issuer '%s' is not allowed", e.issuer)
+}
+
+// WithRequiredClaims rejects tokens that do not contain all of the given claims
+func WithRequiredClaims(claims ...string) JwsDecoderOption {
+	return func(d *jwsDecoder) {
+		d.policy.required = append(d.policy.required, claims...)
+	}
+}
+
+// AudienceMatch defines how the `aud` claim of a token is matched against the expected audiences
+type AudienceMatch string
+
+// Audience match modes
+const (
+	// AnyAudience requires the token to have at least one of the expected audiences
+	AnyAudience AudienceMatch = "any"
+	// AllAudiences requires the token to have all of the expected audiences
+	AllAudiences AudienceMatch = "all"
+)
+
+// WithAudiences requires the `aud` claim of the token to match the expected audiences with the given match,
+// if no audiences are given all audiences are accepted
+func WithAudiences(match AudienceMatch, audiences ...string) JwsDecoderOption {
+	return func(d *jwsDecoder) {
+		d.policy.audMatch = match
+		d.policy.audiences = append(d.policy.audiences, audiences...)
+	}
+}
+
 // NewJwsDecoder returns a root Decoder that can decode and validate JWS Tokens
 // It will also map the claims via the claim mapping
 // `claimMapping = map[string][string]{ "key123", "headerKey123" }`
-// will cause the claim `key123` in the JWS token to be mapped to `headerKey123` in the decoded token
-func NewJwsDecoder(jwksURL string, claimMapping map[string]string) (TokenDecoder, error) {
-	ar := jwk.NewAutoRefresh(context.Background())
-	ar.Configure(jwksURL)
-	d := jwsDecoder{claimMapping: claimMapping, jwksFetcher: ar, jwksURL: jwksURL}
-	_, err := ar.Fetch(context.Background(), jwksURL)
+// will cause the claim `key123` in the JWS token to be mapped to `headerKey123` in the decoded token,
+// nested claims are mapped with a dot path (`realm_access.roles`) or JSON Pointer (`/realm_access/roles`)
+// additional validations can be enabled with the opts
+func NewJwsDecoder(jwksURL string, claimMapping map[string]string, opts ...JwsDecoderOption) (TokenDecoder, error) {
+	d := jwsDecoder{claimMapping: claimMapping}
+	for _, opt := range opts {
+		opt(&d)
+	}
+	keys, err := NewRemoteKeySource(jwksURL, d.remoteOpts...)
+	d.keys = keys
 	return &d, err
 }
 
+// NewKeySourceDecoder returns a Decoder like NewJwsDecoder that verifies the tokens with the keys of the KeySource
+func NewKeySourceDecoder(keys KeySource, claimMapping map[string]string, opts ...JwsDecoderOption) TokenDecoder {
+	d := jwsDecoder{claimMapping: claimMapping, keys: keys}
+	for _, opt := range opts {
+		opt(&d)
+	}
+	return &d
+}
+
 func (d *jwsDecoder) Decode(ctx context.Context, rawJws string) (*Token, error) {
 	jwtToken, err := d.parseAndValidate(ctx, rawJws)
 	if err != nil {
 		return nil, err
 	}
+	if err = d.policy.validate(jwtToken.Issuer(), jwtToken.Audience(), jwtToken.Get); err != nil {
+		return nil, err
+	}
 	token := &Token{
+		ID:         jwtToken.JwtID(),
+		Subject:    jwtToken.Subject(),
 		Expiration: jwtToken.Expiration(),
-		Claims:     make(map[string]string),
+		NotBefore:  jwtToken.NotBefore(),
+		IssuedAt:   jwtToken.IssuedAt(),
+		AuthTime:   numericDateClaim(jwtToken, "auth_time"),
 	}
-	for key, destKey := range d.claimMapping {
-		if value, ok := jwtToken.Get(key); ok {
+	cnf, _ := jwtToken.Get("cnf")
+	token.KeyThumbprint = confirmation(cnf, "jkt")
+	token.CertificateThumbprint = confirmation(cnf, "x5t#S256")
+	if token.Claims, token.values, err = mapClaims(d.claimMapping, jwtToken.Get); err != nil {
+		return nil, err
+	}
+	token.formats = d.formats
+	return token, nil
+}
+
+// mapClaims maps the claims (or nested claims at a claim path) returned by get to their header,
+// string claims are used as is and all other claims are json encoded. The claim values are returned by header too
+func mapClaims(claimMapping map[string]string, get func(string) (interface{}, bool)) (map[string]string, map[string]interface{}, error) {
+	claims := make(map[string]string)
+	values := make(map[string]interface{})
+	for key, destKey := range claimMapping {
+		if value, ok := lookupClaim(get, key); ok {
 			if strVal, ok := value.(string); ok {
-				token.Claims[destKey] = strVal
+				claims[destKey] = strVal
 			} else {
 				strJSON, err := json.Marshal(value)
 
 				if err != nil {
-					return nil, UnexpectedClaimTypeError{key, value}
+					return nil, nil, UnexpectedClaimTypeError{key, value}
 				}
 
-				token.Claims[destKey] = string(strJSON)
+				claims[destKey] = string(strJSON)
 			}
+			values[destKey] = value
 		}
 	}
-	return token, nil
+	return claims, values, nil
+}
+
+// confirmation returns the member of the cnf claim (RFC 7800) or an empty string if it is missing
+func confirmation(cnf interface{}, member string) string {
+	if cnf, ok := cnf.(map[string]interface{}); ok {
+		value, _ := cnf[member].(string)
+		return value
+	}
+	return ""
+}
+
+// numericDateClaim returns the time of a non standard NumericDate claim or the zero time if it is missing
+func numericDateClaim(t jwt.Token, name string) time.Time {
+	value, ok := t.Get(name)
+	if !ok {
+		return time.Time{}
+	}
+	return numericDate(value)
+}
+
+// numericDate returns the time of a NumericDate value or the zero time if it is not a NumericDate
+func numericDate(value interface{}) time.Time {
+	switch v := value.(type) {
+	case float64:
+		return time.Unix(int64(v), 0)
+	case json.Number:
+		if secs, err := v.Int64(); err == nil {
+			return time.Unix(secs, 0)
+		}
+	case time.Time:
+		return v
+	}
+	return time.Time{}
 }
 
 func (d *jwsDecoder) parseAndValidate(ctx context.Context, rawJws string) (jwt.Token, error) {
-	jwks, err := d.jwksFetcher.Fetch(ctx, d.jwksURL)
+	jwks, err := d.keys.Keys(ctx)
 	if err != nil {
 		return nil, err
 	}
-	_, err = jws.VerifySet([]byte(rawJws), jwks)
+	headers, err := d.verify(ctx, []byte(rawJws), jwks)
+	if _, ok := err.(UnknownKeyError); ok && d.refreshInterval > 0 {
+		if source, ok := d.keys.(refreshableKeySource); !ok || !isRemote(d.keys) {
+			log.Ctx(ctx).Debug().Msg("not refetching keys for unknown kid, the keys are not fetched from a JWKS url")
+		} else if jwks, refreshErr := d.refreshKeys(ctx, source); refreshErr == nil {
+			headers, err = d.verify(ctx, []byte(rawJws), jwks)
+		} else {
+			log.Ctx(ctx).Warn().Err(refreshErr).Msg("unable to refetch keys")
+		}
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to verify token with jwks: %w", err)
+		return nil, err
+	}
+	if err = d.validateHeaders(headers); err != nil {
+		return nil, err
 	}
 	t, err := jwt.ParseString(rawJws)
 	if err != nil {
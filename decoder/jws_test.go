@@ -1,9 +1,17 @@
 package decoder_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+	"github.com/lestrrat-go/jwx/jwa"
 
 	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
 )
@@ -38,3 +46,109 @@ func TestTokenWithInvalidClaims(t *testing.T) {
 		dt.Report(t, err != nil, "not able to decode token with unusual JSON type: (%s : %+v) into %+v", k, v, resp)
 	}
 }
+
+func TestTokenWithDisallowedIssuer(t *testing.T) {
+	tc := dt.NewTest()
+	dec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string), decoder.WithIssuers("https://issuer.one", "https://issuer.two"))
+	for _, iss := range []string{"https://issuer.one", "https://issuer.two"} {
+		token := tc.NewValidToken(map[string]interface{}{"iss": iss})
+		_, err := dec.Decode(dt.Ctx(), string(token))
+		dt.Report(t, err != nil, "token from allowed issuer %s was rejected: %s", iss, err)
+	}
+	token := tc.NewValidToken(map[string]interface{}{"iss": "https://issuer.three"})
+	_, err := dec.Decode(dt.Ctx(), string(token))
+	var issErr decoder.InvalidIssuerError
+	dt.Report(t, !errors.As(err, &issErr), "expected InvalidIssuerError got %v", err)
+}
+
+func TestTokenAudienceValidation(t *testing.T) {
+	tc := dt.NewTest()
+	expected := []string{"service-a", "service-b"}
+	tests := map[string]struct {
+		match    decoder.AudienceMatch
+		audience interface{}
+		valid    bool
+	}{
+		"AnyWithString":       {match: decoder.AnyAudience, audience: "service-a", valid: true},
+		"AnyWithArray":        {match: decoder.AnyAudience, audience: []string{"service-c", "service-b"}, valid: true},
+		"AnyWithOtherString":  {match: decoder.AnyAudience, audience: "service-c", valid: false},
+		"AnyWithOtherArray":   {match: decoder.AnyAudience, audience: []string{"service-c", "service-d"}, valid: false},
+		"AllWithArray":        {match: decoder.AllAudiences, audience: []string{"service-b", "service-c", "service-a"}, valid: true},
+		"AllWithString":       {match: decoder.AllAudiences, audience: "service-a", valid: false},
+		"AllWithPartialArray": {match: decoder.AllAudiences, audience: []string{"service-a", "service-c"}, valid: false},
+		"AnyWithMissingClaim": {match: decoder.AnyAudience, audience: nil, valid: false},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string), decoder.WithAudiences(test.match, expected...))
+			claims := map[string]interface{}{}
+			if test.audience != nil {
+				claims["aud"] = test.audience
+			}
+			_, err := dec.Decode(dt.Ctx(), string(tc.NewValidToken(claims)))
+			var audErr decoder.InvalidAudienceError
+			if test.valid {
+				dt.Report(t, err != nil, "expected token to be valid got %v", err)
+			} else {
+				dt.Report(t, !errors.As(err, &audErr), "expected InvalidAudienceError got %v", err)
+			}
+		})
+	}
+}
+
+func TestTokenAlgorithmValidation(t *testing.T) {
+	tc := dt.NewTest()
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	validToken := tc.NewValidToken(map[string]interface{}{})
+	tests := map[string]struct {
+		token []byte
+		algs  []jwa.SignatureAlgorithm
+		err   interface{}
+	}{
+		"AllowedAlgorithm":      {token: validToken, algs: []jwa.SignatureAlgorithm{jwa.RS256, jwa.ES256}},
+		"NoAllowlist":           {token: validToken},
+		"DisallowedAlgorithm":   {token: validToken, algs: []jwa.SignatureAlgorithm{jwa.ES256}, err: new(decoder.UnsupportedAlgorithmError)},
+		"NoneAlgorithm":         {token: unsignedToken(), err: new(decoder.UnsupportedAlgorithmError)},
+		"HmacWithPublicKey":     {token: tc.NewTokenSignedWith(map[string]interface{}{}, jwa.HS256, []byte("secret")), err: new(decoder.UnsupportedAlgorithmError)},
+		"EcdsaWithRsaKey":       {token: tc.NewTokenSignedWith(map[string]interface{}{}, jwa.ES256, ecKey), err: new(decoder.AlgorithmMismatchError)},
+		"AllowedHmacWithRsaKey": {token: tc.NewTokenSignedWith(map[string]interface{}{}, jwa.HS256, []byte("secret")), algs: []jwa.SignatureAlgorithm{jwa.RS256, jwa.HS256}, err: new(decoder.UnsupportedAlgorithmError)},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string), decoder.WithAlgorithms(test.algs...))
+			_, err := dec.Decode(dt.Ctx(), string(test.token))
+			if test.err == nil {
+				dt.Report(t, err != nil, "expected token to be valid got %v", err)
+				return
+			}
+			dt.Report(t, !errors.As(err, test.err), "expected error of type %T got %v", test.err, err)
+		})
+	}
+}
+
+func TestTokenWithMissingRequiredClaim(t *testing.T) {
+	tc := dt.NewTest()
+	dec, _ := decoder.NewJwsDecoder(tc.JwksURL, map[string]string{"email": "jwt-token-email"}, decoder.WithRequiredClaims("email", "sub"))
+	_, err := dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{"email": "a@b.c", "sub": "123"})))
+	dt.Report(t, err != nil, "token with all required claims was rejected: %s", err)
+	_, err = dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{"sub": "123"})))
+	var claimErr decoder.MissingClaimError
+	dt.Report(t, !errors.As(err, &claimErr), "expected MissingClaimError got %v", err)
+	dt.Report(t, !strings.Contains(err.Error(), "email"), "error should name the missing claim: %s", err)
+}
+
+func TestTokenTimeClaims(t *testing.T) {
+	tc := dt.NewTest()
+	dec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string))
+	at := time.Now().Add(-time.Hour).Truncate(time.Second)
+	token, err := dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{"iat": at, "nbf": at, "auth_time": at.Unix()})))
+	dt.Report(t, err != nil, "unable to decode token %s", err)
+	dt.Report(t, !token.IssuedAt.Equal(at), "incorrect iat %s expected %s", token.IssuedAt, at)
+	dt.Report(t, !token.NotBefore.Equal(at), "incorrect nbf %s expected %s", token.NotBefore, at)
+	dt.Report(t, !token.AuthTime.Equal(at), "incorrect auth_time %s expected %s", token.AuthTime, at)
+}
+
+func unsignedToken() []byte {
+	enc := base64.RawURLEncoding
+	return []byte(strings.Join([]string{enc.EncodeToString([]byte(`{"alg":"none"}`)), enc.EncodeToString([]byte(`{"sub":"123"}`)), ""}, "."))
+}
@@ -0,0 +1,286 @@
+package decoder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/rs/zerolog/log"
+)
+
+// KeySource provides the keys used to verify the tokens
+type KeySource interface {
+	Keys(ctx context.Context) (jwk.Set, error)
+}
+
+// refreshableKeySource is a KeySource that can reload its keys on demand
+type refreshableKeySource interface {
+	KeySource
+	Refresh(ctx context.Context) (jwk.Set, error)
+}
+
+type remoteKeySource struct {
+	jwksURL      string
+	jwksFetcher  *jwk.AutoRefresh
+	client       *http.Client
+	snapshotDir  string
+	maxStaleness time.Duration
+	// snapshotMutex serializes writing the snapshot, savedKeys are the keys in the snapshot
+	snapshotMutex sync.Mutex
+	savedKeys     jwk.Set
+	mutex         sync.Mutex
+	keys          jwk.Set
+	fetchedAt     time.Time
+	failing       bool
+	lastRetry     time.Time
+	fetched       bool
+	fetchErr      error
+	retrying      bool
+	cancel        context.CancelFunc
+	gracePeriod   time.Duration
+	retiredMutex  sync.Mutex
+	current       jwk.Set
+	merged        jwk.Set
+	retired       map[string]retiredKey
+}
+
+// KeySourceError is thrown if the keys could not be fetched from the JWKS and there are no last good keys
+type KeySourceError struct {
+	jwksURL string
+	err     error
+}
+
+func (e KeySourceError) Error() string {
+	return fmt.Sprintf("unable to fetch keys of %s: %s", e.jwksURL, e.err)
+}
+
+func (e KeySourceError) Unwrap() error {
+	return e.err
+}
+
+// Temporary returns true as the JWKS might be fetched on the next request
+func (e KeySourceError) Temporary() bool {
+	return true
+}
+
+// RemoteKeySourceOption configures optional behaviour of the remote key source
+type RemoteKeySourceOption func(s *remoteKeySource)
+
+// WithHTTPClient fetches the JWKS with the client instead of the default http client
+func WithHTTPClient(client *http.Client) RemoteKeySourceOption {
+	return func(s *remoteKeySource) {
+		s.client = client
+	}
+}
+
+// NewRemoteKeySource returns a KeySource with the JWKS at jwksURL which is refreshed in the background,
+// the source is returned with an error if the initial fetch fails and will try again in the background
+// when the keys are requested, until then the snapshot is used if there is one
+func NewRemoteKeySource(jwksURL string, opts ...RemoteKeySourceOption) (KeySource, error) {
+	return newRemoteKeySource(jwksURL, opts...)
+}
+
+func newRemoteKeySource(jwksURL string, opts ...RemoteKeySourceOption) (*remoteKeySource, error) {
+	s := &remoteKeySource{jwksURL: jwksURL, retired: make(map[string]retiredKey)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.snapshotDir != "" && s.maxStaleness <= 0 {
+		s.maxStaleness = DefaultSnapshotMaxStaleness
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.jwksFetcher, s.cancel = jwk.NewAutoRefresh(ctx), cancel
+	var fetchOpts []jwk.AutoRefreshOption
+	if s.client != nil {
+		fetchOpts = append(fetchOpts, jwk.WithHTTPClient(s.client))
+	}
+	s.jwksFetcher.Configure(jwksURL, fetchOpts...)
+	if s.tracksStaleness() {
+		s.loadSnapshot()
+		errs := make(chan jwk.AutoRefreshError, 1)
+		s.jwksFetcher.ErrorSink(errs)
+		go s.watchErrors(ctx, errs)
+	}
+	_, err := s.Refresh(context.Background())
+	return s, err
+}
+
+func (s *remoteKeySource) Keys(ctx context.Context) (jwk.Set, error) {
+	s.mutex.Lock()
+	fetched, fetchErr := s.fetched, s.fetchErr
+	s.mutex.Unlock()
+	if !fetched {
+		// the fetcher fetches synchronously until it has keys, which would block every request
+		s.fetchInBackground()
+		if s.tracksStaleness() {
+			return s.withRetiredKeys(s.lastGoodKeys(ctx, nil, fetchErr))
+		}
+		return nil, fetchErr
+	}
+	keys, err := s.jwksFetcher.Fetch(ctx, s.jwksURL)
+	if s.tracksStaleness() {
+		keys, err = s.lastGoodKeys(ctx, keys, err)
+		if _, ok := err.(StaleKeysError); ok && s.retryDue() {
+			return s.Refresh(ctx)
+		}
+	}
+	return s.withRetiredKeys(keys, err)
+}
+
+func (s *remoteKeySource) Refresh(ctx context.Context) (jwk.Set, error) {
+	keys, err := s.jwksFetcher.Refresh(ctx, s.jwksURL)
+	s.mutex.Lock()
+	if err != nil {
+		err = KeySourceError{jwksURL: s.jwksURL, err: err}
+		if !s.fetched {
+			s.fetchErr = err
+		}
+	} else {
+		s.fetched, s.fetchErr = true, nil
+	}
+	s.mutex.Unlock()
+	if s.tracksStaleness() {
+		keys, err = s.lastGoodKeys(ctx, keys, err)
+	}
+	return s.withRetiredKeys(keys, err)
+}
+
+// close stops refreshing the keys in the background
+func (s *remoteKeySource) close() {
+	s.cancel()
+}
+
+// fetchInBackground retries the failed initial fetch without blocking the request,
+// at most one fetch runs at a time and fetches are at least staleRetryInterval apart
+func (s *remoteKeySource) fetchInBackground() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.retrying || time.Since(s.lastRetry) < staleRetryInterval {
+		return
+	}
+	s.retrying, s.lastRetry = true, time.Now()
+	go func() {
+		if _, err := s.Refresh(context.Background()); err != nil {
+			log.Warn().Err(err).Str("jwks", s.jwksURL).Msg("unable to fetch keys")
+		}
+		s.mutex.Lock()
+		s.retrying = false
+		s.mutex.Unlock()
+	}()
+}
+
+type staticKeySource struct {
+	keys jwk.Set
+}
+
+// NewStaticKeySource returns a KeySource with a fixed set of keys
+func NewStaticKeySource(keys jwk.Set) KeySource {
+	return &staticKeySource{keys: keys}
+}
+
+func (s *staticKeySource) Keys(ctx context.Context) (jwk.Set, error) {
+	return s.keys, nil
+}
+
+// ParseKeys parses the public keys of a JWKS or of PEM encoded keys and certificates
+func ParseKeys(data []byte, pem bool) (jwk.Set, error) {
+	keys, err := jwk.Parse(data, jwk.WithPEM(pem))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse keys: %w", err)
+	}
+	return jwk.PublicSetOf(keys)
+}
+
+type fileKeySource struct {
+	pem   bool
+	mutex sync.RWMutex
+	keys  jwk.Set
+	file  *fileWatcher
+}
+
+// NewFileKeySource returns a KeySource with the keys (JWKS or PEM) in the file at path,
+// the file is reloaded when it is modified, which is checked every pollInterval
+func NewFileKeySource(path string, pem bool, pollInterval time.Duration) (KeySource, error) {
+	s := &fileKeySource{pem: pem}
+	s.file = &fileWatcher{path: path, name: "keys", load: s.load}
+	if err := s.file.reload(); err != nil {
+		return nil, err
+	}
+	go s.file.watch(pollInterval, nil)
+	return s, nil
+}
+
+func (s *fileKeySource) Keys(ctx context.Context) (jwk.Set, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.keys, nil
+}
+
+func (s *fileKeySource) Refresh(ctx context.Context) (jwk.Set, error) {
+	if err := s.file.reload(); err != nil {
+		return nil, err
+	}
+	return s.Keys(ctx)
+}
+
+func (s *fileKeySource) load(data []byte) error {
+	keys, err := ParseKeys(data, s.pem)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.keys = keys
+	s.mutex.Unlock()
+	log.Info().Str("path", s.file.path).Int("keys", keys.Len()).Msg("loaded keys from file")
+	return nil
+}
+
+type multiKeySource struct {
+	sources []KeySource
+}
+
+// NewMultiKeySource returns a KeySource with the keys of all sources,
+// sources that fail are skipped as long as at least one source succeeds
+func NewMultiKeySource(sources ...KeySource) KeySource {
+	if len(sources) == 1 {
+		return sources[0]
+	}
+	return &multiKeySource{sources: sources}
+}
+
+// Refresh refreshes all refreshable sources and returns the keys of all sources
+func (s *multiKeySource) Refresh(ctx context.Context) (jwk.Set, error) {
+	for _, source := range s.sources {
+		if r, ok := source.(refreshableKeySource); ok {
+			if _, err := r.Refresh(ctx); err != nil {
+				log.Ctx(ctx).Warn().Err(err).Msg("unable to refresh keys of source")
+			}
+		}
+	}
+	return s.Keys(ctx)
+}
+
+func (s *multiKeySource) Keys(ctx context.Context) (jwk.Set, error) {
+	merged := jwk.NewSet()
+	var lastErr error
+	failed := 0
+	for _, source := range s.sources {
+		keys, err := source.Keys(ctx)
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("unable to get keys from source")
+			lastErr = err
+			failed++
+			continue
+		}
+		for iter := keys.Iterate(ctx); iter.Next(ctx); {
+			merged.Add(iter.Pair().Value.(jwk.Key))
+		}
+	}
+	if failed == len(s.sources) {
+		return nil, lastErr
+	}
+	return merged, nil
+}
@@ -0,0 +1,68 @@
+package decoder_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func TestStaticKeySources(t *testing.T) {
+	tc := dt.NewTest()
+	tests := map[string]struct {
+		data []byte
+		pem  bool
+	}{
+		"JWKS": {data: tc.JWKS()},
+		"PEM":  {data: tc.PublicKeyPEM(), pem: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			keys, err := decoder.ParseKeys(test.data, test.pem)
+			dt.Report(t, err != nil, "unable to parse keys %s", err)
+			dec := decoder.NewKeySourceDecoder(decoder.NewStaticKeySource(keys), make(map[string]string))
+			_, err = dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{})))
+			dt.Report(t, err != nil, "unable to decode token with static keys %s", err)
+			_, err = dec.Decode(dt.Ctx(), string(tc.NewInvalidToken(map[string]interface{}{})))
+			dt.Report(t, err == nil, "decoded token signed with unknown key")
+		})
+	}
+}
+
+func TestFileKeySourceReloads(t *testing.T) {
+	before, after := dt.NewTest(), dt.NewTest()
+	file, err := ioutil.TempFile(".", "jwks.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	file.Write(before.JWKS())
+	file.Close()
+	source, err := decoder.NewFileKeySource(file.Name(), false, 10*time.Millisecond)
+	dt.Report(t, err != nil, "unable to load keys from file %s", err)
+	dec := decoder.NewKeySourceDecoder(source, make(map[string]string))
+	_, err = dec.Decode(dt.Ctx(), string(before.NewValidToken(map[string]interface{}{})))
+	dt.Report(t, err != nil, "unable to decode token with keys from file %s", err)
+
+	dt.HandleByPanic(ioutil.WriteFile(file.Name(), after.JWKS(), 0600))
+	dt.HandleByPanic(os.Chtimes(file.Name(), time.Now(), time.Now().Add(time.Minute)))
+	time.Sleep(100 * time.Millisecond)
+	_, err = dec.Decode(dt.Ctx(), string(after.NewValidToken(map[string]interface{}{})))
+	dt.Report(t, err != nil, "unable to decode token with reloaded keys %s", err)
+	_, err = dec.Decode(dt.Ctx(), string(before.NewValidToken(map[string]interface{}{})))
+	dt.Report(t, err == nil, "decoded token with keys removed from file")
+}
+
+func TestMultiKeySource(t *testing.T) {
+	remote, local := dt.NewTest(), dt.NewTest()
+	remoteKeys, err := decoder.NewRemoteKeySource(remote.JwksURL)
+	dt.HandleByPanic(err)
+	localKeys, err := decoder.ParseKeys(local.JWKS(), false)
+	dt.HandleByPanic(err)
+	dec := decoder.NewKeySourceDecoder(decoder.NewMultiKeySource(remoteKeys, decoder.NewStaticKeySource(localKeys)), make(map[string]string))
+	for name, tc := range map[string]*dt.TestConfig{"remote": remote, "local": local} {
+		_, err := dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{})))
+		dt.Report(t, err != nil, "unable to decode token signed with %s key %s", name, err)
+	}
+}
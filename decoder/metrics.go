@@ -0,0 +1,87 @@
+package decoder
+
+import (
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "traefik_jwt_decode"
+
+var (
+	rejectedAlgorithms = prom.NewCounterVec(prom.CounterOpts{Namespace: metricsNamespace, Subsystem: "decoder",
+		Name: "rejected_algorithms_total", Help: "tokens rejected because of their signing algorithm"}, []string{"alg"})
+	rejectedTokens = prom.NewCounterVec(prom.CounterOpts{Namespace: metricsNamespace, Subsystem: "server",
+		Name: "rejected_tokens_total", Help: "requests rejected because of an invalid token"}, []string{"reason"})
+	decryptionFailures = prom.NewCounterVec(prom.CounterOpts{Namespace: metricsNamespace, Subsystem: "decoder",
+		Name: "decryption_failures_total", Help: "encrypted tokens that could not be decrypted"}, []string{"alg"})
+	keyRefreshes = prom.NewCounter(prom.CounterOpts{Namespace: metricsNamespace, Subsystem: "decoder",
+		Name: "key_refreshes_total", Help: "keys refetched because of a token with an unknown kid"})
+	staleKeys = prom.NewGaugeVec(prom.GaugeOpts{Namespace: metricsNamespace, Subsystem: "keys",
+		Name: "stale", Help: "1 if the last good keys are used because the jwks can't be refreshed"}, []string{"jwks"})
+	keysFetchedAt = prom.NewGaugeVec(prom.GaugeOpts{Namespace: metricsNamespace, Subsystem: "keys",
+		Name: "last_fetch_timestamp_seconds", Help: "when the keys in use were fetched"}, []string{"jwks"})
+	retiredKeys = prom.NewGaugeVec(prom.GaugeOpts{Namespace: metricsNamespace, Subsystem: "keys",
+		Name: "retired", Help: "keys removed from the jwks that are still accepted for the grace period"}, []string{"jwks"})
+	retiredKeyVerifications = prom.NewCounterVec(prom.CounterOpts{Namespace: metricsNamespace, Subsystem: "decoder",
+		Name: "retired_key_verifications_total", Help: "tokens verified with a key removed from the jwks"}, []string{"jwks"})
+)
+
+// RegisterMetrics registers the metrics of the decoders and server in the registerer
+func RegisterMetrics(r prom.Registerer) {
+	r.MustRegister(rejectedAlgorithms, rejectedTokens, decryptionFailures, keyRefreshes, staleKeys, keysFetchedAt,
+		retiredKeys, retiredKeyVerifications)
+}
+
+// rejectionReason returns a short reason for why the token was rejected with the error
+func rejectionReason(err error) string {
+	switch err.(type) {
+	case TokenExpiredError:
+		return "expired"
+	case TokenNotYetValidError:
+		return "not_yet_valid"
+	case TokenIssuedInFutureError:
+		return "issued_in_future"
+	case TokenLifetimeExceededError:
+		return "lifetime_exceeded"
+	case TokenTooOldError:
+		return "too_old"
+	case MissingClaimError:
+		return "missing_claim"
+	case InvalidIssuerError:
+		return "invalid_issuer"
+	case InvalidAudienceError:
+		return "invalid_audience"
+	case UnsupportedAlgorithmError, AlgorithmMismatchError:
+		return "invalid_algorithm"
+	case KeyUsageError:
+		return "invalid_key_usage"
+	case UnknownKeyError:
+		return "unknown_key"
+	case StaleKeysError:
+		return "stale_keys"
+	case KeySourceError:
+		return "keys_unavailable"
+	case InvalidTokenTypeError, UnsupportedCriticalHeaderError:
+		return "invalid_header"
+	case UnsupportedKeyEncryptionError, DecryptionError:
+		return "decryption_failed"
+	case TokenRevokedError:
+		return "revoked"
+	case InactiveTokenError:
+		return "inactive"
+	case IntrospectionError:
+		return "introspection_failed"
+	case InvalidProofError:
+		return "invalid_dpop_proof"
+	case ProofReplayedError:
+		return "dpop_proof_replayed"
+	case TokenBindingError:
+		return "invalid_token_binding"
+	case CertificateBindingError:
+		return "invalid_certificate_binding"
+	case InvalidCredentialsError:
+		return "invalid_credentials"
+	case AmbiguousCredentialsError:
+		return "ambiguous_credentials"
+	}
+	return "invalid_token"
+}
@@ -0,0 +1,93 @@
+package decoder
+
+// ClaimPolicy restricts the accepted tokens by their claims, it is validated for JWTs and introspected opaque tokens
+type ClaimPolicy struct {
+	// Issuers are the allowed `iss` claims, all issuers are accepted if empty
+	Issuers []string
+	// Audiences are the expected `aud` claims matched with AudienceMatch, all audiences are accepted if empty
+	Audiences     []string
+	AudienceMatch AudienceMatch
+	// RequiredClaims are the claims (or claim paths) every token has to contain
+	RequiredClaims []string
+}
+
+// WithClaimPolicy validates the claims of the tokens with the policy like WithIssuers, WithAudiences
+// and WithRequiredClaims
+func WithClaimPolicy(policy ClaimPolicy) JwsDecoderOption {
+	return func(d *jwsDecoder) {
+		d.policy.apply(policy)
+	}
+}
+
+// claimPolicy is the ClaimPolicy shared by the decoders, issuers is nil if all issuers are accepted
+type claimPolicy struct {
+	issuers   map[string]bool
+	audiences []string
+	audMatch  AudienceMatch
+	required  []string
+}
+
+func newClaimPolicy(policy ClaimPolicy) claimPolicy {
+	var p claimPolicy
+	p.apply(policy)
+	return p
+}
+
+func (p *claimPolicy) apply(policy ClaimPolicy) {
+	p.allowIssuers(policy.Issuers)
+	if len(policy.Audiences) > 0 {
+		p.audMatch = policy.AudienceMatch
+		p.audiences = append(p.audiences, policy.Audiences...)
+	}
+	p.required = append(p.required, policy.RequiredClaims...)
+}
+
+// allowIssuers restricts the issuers to the given issuers, only the issuers allowed by all calls are accepted
+func (p *claimPolicy) allowIssuers(issuers []string) {
+	if len(issuers) == 0 {
+		return
+	}
+	allowed := make(map[string]bool)
+	for _, iss := range issuers {
+		if p.issuers == nil || p.issuers[iss] {
+			allowed[iss] = true
+		}
+	}
+	p.issuers = allowed
+}
+
+// validate rejects tokens from issuers that are not allowed, for unexpected audiences
+// or without all required claims of the claims returned by get
+func (p *claimPolicy) validate(issuer string, audience []string, get func(string) (interface{}, bool)) error {
+	if p.issuers != nil && !p.issuers[issuer] {
+		return InvalidIssuerError{issuer}
+	}
+	if !p.validAudience(audience) {
+		return InvalidAudienceError{audience}
+	}
+	for _, key := range p.required {
+		if _, ok := lookupClaim(get, key); !ok {
+			return MissingClaimError{key}
+		}
+	}
+	return nil
+}
+
+func (p *claimPolicy) validAudience(tokenAudience []string) bool {
+	if len(p.audiences) == 0 {
+		return true
+	}
+	matches := 0
+	for _, expected := range p.audiences {
+		for _, aud := range tokenAudience {
+			if aud == expected {
+				matches++
+				break
+			}
+		}
+	}
+	if p.audMatch == AllAudiences {
+		return matches == len(p.audiences)
+	}
+	return matches > 0
+}
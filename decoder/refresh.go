@@ -0,0 +1,63 @@
+package decoder
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/rs/zerolog/log"
+)
+
+// UnknownKeyError is thrown if there is no key with the kid of the token
+type UnknownKeyError struct {
+	kid string
+}
+
+func (e UnknownKeyError) Error() string {
+	return fmt.Sprintf("no key with kid '%s'", e.kid)
+}
+
+// Temporary returns true as the key might be published with the next keys
+func (e UnknownKeyError) Temporary() bool {
+	return true
+}
+
+// WithKeyRefresh refetches the keys when a token is signed with an unknown kid and retries the verification,
+// the keys are refetched at most once every minInterval so random kids can't be used to overload the key source.
+// Only remote key sources (JWKS urls) are refetched
+func WithKeyRefresh(minInterval time.Duration) JwsDecoderOption {
+	return func(d *jwsDecoder) {
+		d.refreshInterval = minInterval
+	}
+}
+
+// isRemote returns true if the keys are (or include) keys fetched from a JWKS url which can be refetched
+func isRemote(source KeySource) bool {
+	switch s := source.(type) {
+	case *remoteKeySource:
+		return true
+	case *multiKeySource:
+		for _, source := range s.sources {
+			if isRemote(source) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// refreshKeys refetches the keys if the last refetch is at least refreshInterval ago, otherwise the current keys
+// are returned as they may have been refetched by a concurrent call
+func (d *jwsDecoder) refreshKeys(ctx context.Context, source refreshableKeySource) (jwk.Set, error) {
+	d.refreshMutex.Lock()
+	defer d.refreshMutex.Unlock()
+	if time.Since(d.lastRefresh) < d.refreshInterval {
+		log.Ctx(ctx).Debug().Msg("not refetching keys for unknown kid, the keys have been refetched recently")
+		return d.keys.Keys(ctx)
+	}
+	d.lastRefresh = time.Now()
+	keyRefreshes.Inc()
+	log.Ctx(ctx).Info().Msg("refetching keys for unknown kid")
+	return source.Refresh(ctx)
+}
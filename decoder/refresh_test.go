@@ -0,0 +1,139 @@
+package decoder_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+type rotatingJwksServer struct {
+	mutex   sync.Mutex
+	jwks    []byte
+	fetches int
+	down    bool
+	delay   time.Duration
+}
+
+func (s *rotatingJwksServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	delay := s.delay
+	s.mutex.Unlock()
+	time.Sleep(delay)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fetches++
+	if s.down {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	rw.Write(s.jwks)
+}
+
+func (s *rotatingJwksServer) setDelay(delay time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.delay = delay
+}
+
+func (s *rotatingJwksServer) setDown(down bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.down = down
+}
+
+func (s *rotatingJwksServer) rotate(jwks []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.jwks = jwks
+}
+
+func (s *rotatingJwksServer) fetchCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.fetches
+}
+
+func TestKeyRefreshForUnknownKid(t *testing.T) {
+	before, after := dt.NewTest(), dt.NewTest()
+	jwks := &rotatingJwksServer{jwks: before.JWKS()}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	keys, err := decoder.NewRemoteKeySource(server.URL)
+	dt.HandleByPanic(err)
+	dec := decoder.NewKeySourceDecoder(keys, make(map[string]string), decoder.WithKeyRefresh(time.Hour))
+
+	jwks.rotate(after.JWKS())
+	_, err = dec.Decode(dt.Ctx(), string(after.NewValidToken(map[string]interface{}{})))
+	dt.Report(t, err != nil, "expected token signed with rotated key to be valid got %v", err)
+	dt.Report(t, jwks.fetchCount() != 2, "expected the keys to be refetched once got %d fetches", jwks.fetchCount())
+
+	for i := 0; i < 10; i++ {
+		token := after.NewTokenWithHeaders(map[string]interface{}{}, map[string]interface{}{"kid": fmt.Sprintf("random-%d", i)})
+		_, err = dec.Decode(dt.Ctx(), string(token))
+		var unknownKeyErr decoder.UnknownKeyError
+		dt.Report(t, !errors.As(err, &unknownKeyErr), "expected UnknownKeyError got %v", err)
+	}
+	dt.Report(t, jwks.fetchCount() != 2, "expected refetches to be rate limited got %d fetches", jwks.fetchCount())
+}
+
+func TestUnknownKeyIsNotCached(t *testing.T) {
+	before, after := dt.NewTest(), dt.NewTest()
+	jwks := &rotatingJwksServer{jwks: before.JWKS()}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	keys, err := decoder.NewRemoteKeySource(server.URL)
+	dt.HandleByPanic(err)
+	dec := decoder.NewCachedJwtDecoder(dt.Cache, decoder.NewKeySourceDecoder(keys, make(map[string]string), decoder.WithKeyRefresh(50*time.Millisecond)))
+	token := string(after.NewValidToken(map[string]interface{}{}))
+
+	_, err = dec.Decode(dt.Ctx(), token)
+	var unknownKeyErr decoder.UnknownKeyError
+	dt.Report(t, !errors.As(err, &unknownKeyErr), "expected UnknownKeyError before the rotation got %v", err)
+
+	jwks.rotate(after.JWKS())
+	time.Sleep(100 * time.Millisecond)
+	_, err = dec.Decode(dt.Ctx(), token)
+	dt.Report(t, err != nil, "expected token to be valid after the rotation got %v", err)
+}
+
+func TestNoKeyRefreshWithoutOption(t *testing.T) {
+	before, after := dt.NewTest(), dt.NewTest()
+	jwks := &rotatingJwksServer{jwks: before.JWKS()}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	keys, err := decoder.NewRemoteKeySource(server.URL)
+	dt.HandleByPanic(err)
+	dec := decoder.NewKeySourceDecoder(keys, make(map[string]string))
+
+	jwks.rotate(after.JWKS())
+	_, err = dec.Decode(dt.Ctx(), string(after.NewValidToken(map[string]interface{}{})))
+	var unknownKeyErr decoder.UnknownKeyError
+	dt.Report(t, !errors.As(err, &unknownKeyErr), "expected UnknownKeyError got %v", err)
+	dt.Report(t, jwks.fetchCount() != 1, "expected no refetch got %d fetches", jwks.fetchCount())
+}
+
+func TestNoKeyRefreshForFileKeys(t *testing.T) {
+	before, after := dt.NewTest(), dt.NewTest()
+	file, err := ioutil.TempFile(".", "jwks.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	dt.HandleByPanic(ioutil.WriteFile(file.Name(), before.JWKS(), 0600))
+	keys, err := decoder.NewFileKeySource(file.Name(), false, time.Hour)
+	dt.HandleByPanic(err)
+	dec := decoder.NewKeySourceDecoder(keys, make(map[string]string), decoder.WithKeyRefresh(time.Nanosecond))
+
+	dt.HandleByPanic(ioutil.WriteFile(file.Name(), after.JWKS(), 0600))
+	dt.HandleByPanic(os.Chtimes(file.Name(), time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+	_, err = dec.Decode(dt.Ctx(), string(after.NewValidToken(map[string]interface{}{})))
+	var unknownKeyErr decoder.UnknownKeyError
+	dt.Report(t, !errors.As(err, &unknownKeyErr), "expected file keys not to be reloaded for an unknown kid got %v", err)
+}
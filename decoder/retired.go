@@ -0,0 +1,119 @@
+package decoder
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/rs/zerolog/log"
+)
+
+// retiredKeyParam marks the copies of retired keys with the jwks they were removed from
+const retiredKeyParam = "traefik-jwt-decode-retired-from"
+
+type retiredKey struct {
+	key       jwk.Key
+	retiredAt time.Time
+}
+
+// WithRetiredKeyGracePeriod keeps verifying tokens with keys that were removed from the JWKS for the grace period
+// after the removal was noticed, so tokens signed right before a key rotation stay valid
+func WithRetiredKeyGracePeriod(gracePeriod time.Duration) RemoteKeySourceOption {
+	return func(s *remoteKeySource) {
+		s.gracePeriod = gracePeriod
+	}
+}
+
+// withRetiredKeys returns the keys with the keys that were removed from the JWKS within the grace period
+func (s *remoteKeySource) withRetiredKeys(keys jwk.Set, err error) (jwk.Set, error) {
+	if s.gracePeriod <= 0 || err != nil || keys == nil {
+		return keys, err
+	}
+	s.retiredMutex.Lock()
+	defer s.retiredMutex.Unlock()
+	now := time.Now()
+	changed := false
+	if keys != s.current {
+		present := thumbprints(keys)
+		if s.current != nil {
+			for thumbprint, key := range thumbprints(s.current) {
+				if _, ok := present[thumbprint]; ok {
+					continue
+				}
+				if _, ok := s.retired[thumbprint]; ok {
+					continue
+				}
+				if retired := retire(key, s.jwksURL); retired != nil {
+					s.retired[thumbprint] = retiredKey{key: retired, retiredAt: now}
+					log.Info().Str("jwks", s.jwksURL).Str("kid", key.KeyID()).Dur("gracePeriod", s.gracePeriod).Msg("key removed from jwks, keeping it for the grace period")
+				}
+			}
+		}
+		for thumbprint := range present {
+			delete(s.retired, thumbprint)
+		}
+		s.current, changed = keys, true
+	}
+	for thumbprint, retired := range s.retired {
+		if now.Sub(retired.retiredAt) > s.gracePeriod {
+			delete(s.retired, thumbprint)
+			changed = true
+			log.Info().Str("jwks", s.jwksURL).Str("kid", retired.key.KeyID()).Msg("grace period of retired key is over")
+		}
+	}
+	if changed {
+		s.merged = keys
+		if len(s.retired) > 0 {
+			s.merged = jwk.NewSet()
+			for iter := keys.Iterate(context.Background()); iter.Next(context.Background()); {
+				s.merged.Add(iter.Pair().Value.(jwk.Key))
+			}
+			for _, retired := range s.retired {
+				s.merged.Add(retired.key)
+			}
+		}
+		retiredKeys.WithLabelValues(s.jwksURL).Set(float64(len(s.retired)))
+	}
+	return s.merged, nil
+}
+
+// retire returns a copy of the key marked as retired from the jwks
+func retire(key jwk.Key, jwksURL string) jwk.Key {
+	retired, err := key.Clone()
+	if err != nil {
+		log.Warn().Err(err).Str("jwks", jwksURL).Str("kid", key.KeyID()).Msg("unable to keep retired key")
+		return nil
+	}
+	if err := retired.Set(retiredKeyParam, jwksURL); err != nil {
+		return nil
+	}
+	return retired
+}
+
+// thumbprints returns the keys of the set by their thumbprint, so a key is also retired if its kid is reused
+func thumbprints(keys jwk.Set) map[string]jwk.Key {
+	ctx := context.Background()
+	byThumbprint := make(map[string]jwk.Key, keys.Len())
+	for iter := keys.Iterate(ctx); iter.Next(ctx); {
+		key := iter.Pair().Value.(jwk.Key)
+		thumbprint, err := key.Thumbprint(crypto.SHA256)
+		if err != nil {
+			continue
+		}
+		byThumbprint[base64.RawURLEncoding.EncodeToString(thumbprint)] = key
+	}
+	return byThumbprint
+}
+
+// reportRetiredKey logs and counts tokens verified with a retired key
+func reportRetiredKey(ctx context.Context, key jwk.Key) {
+	jwksURL, ok := key.Get(retiredKeyParam)
+	if !ok {
+		return
+	}
+	jwks, _ := jwksURL.(string)
+	retiredKeyVerifications.WithLabelValues(jwks).Inc()
+	log.Ctx(ctx).Warn().Str("jwks", jwks).Str("kid", key.KeyID()).Msg("token verified with retired key")
+}
@@ -0,0 +1,47 @@
+package decoder_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func TestRetiredKeyGracePeriod(t *testing.T) {
+	before, after := dt.NewTest(), dt.NewTest()
+	jwks := &rotatingJwksServer{jwks: before.JWKS()}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	keys, err := decoder.NewRemoteKeySource(server.URL, decoder.WithRetiredKeyGracePeriod(200*time.Millisecond))
+	dt.HandleByPanic(err)
+	dec := decoder.NewKeySourceDecoder(keys, make(map[string]string), decoder.WithKeyRefresh(time.Millisecond))
+	oldToken := string(before.NewValidToken(map[string]interface{}{}))
+
+	jwks.rotate(after.JWKS())
+	_, err = dec.Decode(dt.Ctx(), string(after.NewValidToken(map[string]interface{}{})))
+	dt.Report(t, err != nil, "expected token signed with rotated key to be valid got %v", err)
+	_, err = dec.Decode(dt.Ctx(), oldToken)
+	dt.Report(t, err != nil, "expected token signed with retired key to be valid within the grace period got %v", err)
+
+	time.Sleep(300 * time.Millisecond)
+	_, err = dec.Decode(dt.Ctx(), oldToken)
+	dt.Report(t, err == nil, "expected token signed with retired key to be rejected after the grace period")
+}
+
+func TestRemovedKeyWithoutGracePeriod(t *testing.T) {
+	before, after := dt.NewTest(), dt.NewTest()
+	jwks := &rotatingJwksServer{jwks: before.JWKS()}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	keys, err := decoder.NewRemoteKeySource(server.URL)
+	dt.HandleByPanic(err)
+	dec := decoder.NewKeySourceDecoder(keys, make(map[string]string), decoder.WithKeyRefresh(time.Millisecond))
+
+	jwks.rotate(after.JWKS())
+	_, err = dec.Decode(dt.Ctx(), string(after.NewValidToken(map[string]interface{}{})))
+	dt.Report(t, err != nil, "expected token signed with rotated key to be valid got %v", err)
+	_, err = dec.Decode(dt.Ctx(), string(before.NewValidToken(map[string]interface{}{})))
+	dt.Report(t, err == nil, "expected token signed with removed key to be rejected")
+}
@@ -0,0 +1,114 @@
+package decoder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TokenRevokedError is thrown if the jti or sub claim of the token is on the revocation list
+type TokenRevokedError struct {
+	claim string
+	value string
+}
+
+func (e TokenRevokedError) Error() string {
+	return fmt.Sprintf("token with %s '%s' is revoked", e.claim, e.value)
+}
+
+// Revocation is an entry of the revocation list, it revokes the token with the jti or all tokens of the sub
+// until exp (unix seconds) after which the entry is dropped. jti entries require the exp of the revoked token,
+// sub entries without exp never expire
+type Revocation struct {
+	JwtID      string `json:"jti"`
+	Subject    string `json:"sub"`
+	Expiration int64  `json:"exp"`
+}
+
+type revocationDecoder struct {
+	mutex    sync.RWMutex
+	jtis     map[string]time.Time
+	subjects map[string]time.Time
+	file     *fileWatcher
+	delegate TokenDecoder
+}
+
+// NewRevocationDecoder returns a TokenDecoder that rejects tokens of the delegate which are revoked by the
+// json list of Revocations in the file at path. The file is reloaded when it is modified, which is checked
+// every pollInterval, the list is checked on every decode so that it also applies to cached tokens
+func NewRevocationDecoder(path string, pollInterval time.Duration, delegate TokenDecoder) (TokenDecoder, error) {
+	d := &revocationDecoder{delegate: delegate}
+	d.file = &fileWatcher{path: path, name: "revocation list", load: d.load}
+	if err := d.file.reload(); err != nil {
+		return nil, fmt.Errorf("unable to load revocation list: %w", err)
+	}
+	go d.file.watch(pollInterval, d.prune)
+	return d, nil
+}
+
+func (d *revocationDecoder) Decode(ctx context.Context, raw string) (*Token, error) {
+	token, err := d.delegate.Decode(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if exp, ok := d.jtis[token.ID]; ok && token.ID != "" && active(exp, now) {
+		return nil, TokenRevokedError{claim: "jti", value: token.ID}
+	}
+	if exp, ok := d.subjects[token.Subject]; ok && token.Subject != "" && active(exp, now) {
+		return nil, TokenRevokedError{claim: "sub", value: token.Subject}
+	}
+	return token, nil
+}
+
+func active(exp, now time.Time) bool {
+	return exp.IsZero() || now.Before(exp)
+}
+
+func (d *revocationDecoder) load(data []byte) error {
+	var revocations []Revocation
+	if err := json.Unmarshal(data, &revocations); err != nil {
+		return err
+	}
+	jtis, subjects := make(map[string]time.Time), make(map[string]time.Time)
+	for _, r := range revocations {
+		var exp time.Time
+		if r.Expiration != 0 {
+			exp = time.Unix(r.Expiration, 0)
+		}
+		if r.JwtID != "" {
+			if exp.IsZero() {
+				return fmt.Errorf("revocation of jti '%s' has no exp", r.JwtID)
+			}
+			jtis[r.JwtID] = exp
+		}
+		if r.Subject != "" {
+			subjects[r.Subject] = exp
+		}
+	}
+	d.mutex.Lock()
+	d.jtis, d.subjects = jtis, subjects
+	d.mutex.Unlock()
+	log.Info().Str("path", d.file.path).Int("jti", len(jtis)).Int("sub", len(subjects)).Msg("loaded revocation list")
+	return nil
+}
+
+// prune drops the expired entries
+func (d *revocationDecoder) prune() {
+	now := time.Now()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, entries := range []map[string]time.Time{d.jtis, d.subjects} {
+		for k, exp := range entries {
+			if !active(exp, now) {
+				delete(entries, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package decoder_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+	"github.com/dgraph-io/ristretto"
+)
+
+func TestRevocationDecoder(t *testing.T) {
+	tc := dt.NewTest()
+	file, err := ioutil.TempFile(".", "revoked.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	writeRevocations(file.Name(), []decoder.Revocation{}, time.Now())
+	cache, _ := ristretto.NewCache(&ristretto.Config{NumCounters: 1000, MaxCost: 1000000, BufferItems: 64})
+	jwsDec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string))
+	dec, err := decoder.NewRevocationDecoder(file.Name(), 10*time.Millisecond, decoder.NewCachedJwtDecoder(cache, jwsDec))
+	dt.HandleByPanic(err)
+
+	leaked := string(tc.NewValidToken(map[string]interface{}{"jti": "leaked", "sub": "alice"}))
+	other := string(tc.NewValidToken(map[string]interface{}{"jti": "other", "sub": "bob"}))
+	stale := string(tc.NewValidToken(map[string]interface{}{"jti": "stale", "sub": "carol"}))
+	for _, token := range []string{leaked, other, stale} {
+		_, err = dec.Decode(dt.Ctx(), token)
+		dt.Report(t, err != nil, "expected token to be valid before revocation got %v", err)
+	}
+
+	writeRevocations(file.Name(), []decoder.Revocation{
+		{JwtID: "leaked", Expiration: time.Now().Add(time.Hour).Unix()},
+		{Subject: "bob"},
+		{JwtID: "stale", Expiration: time.Now().Add(-time.Minute).Unix()},
+	}, time.Now().Add(time.Minute))
+	waitFor(t, func() bool {
+		_, err := dec.Decode(dt.Ctx(), leaked)
+		return err != nil
+	}, "expected the revocation list to be reloaded")
+	tests := map[string]struct {
+		token   string
+		revoked bool
+	}{
+		"RevokedJti":     {token: leaked, revoked: true},
+		"RevokedSubject": {token: other, revoked: true},
+		"ExpiredEntry":   {token: stale},
+		"NotRevoked":     {token: string(tc.NewValidToken(map[string]interface{}{"jti": "new", "sub": "alice"}))},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := dec.Decode(dt.Ctx(), test.token)
+			if !test.revoked {
+				dt.Report(t, err != nil, "expected token to be valid got %v", err)
+				return
+			}
+			var revokedErr decoder.TokenRevokedError
+			dt.Report(t, !errors.As(err, &revokedErr), "expected TokenRevokedError got %v", err)
+		})
+	}
+}
+
+func TestRevokedJwtIDRequiresExpiration(t *testing.T) {
+	tc := dt.NewTest()
+	file, err := ioutil.TempFile(".", "revoked.json")
+	dt.HandleByPanic(err)
+	defer os.Remove(file.Name())
+	jwsDec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string))
+	writeRevocations(file.Name(), []decoder.Revocation{{JwtID: "leaked"}}, time.Now())
+	_, err = decoder.NewRevocationDecoder(file.Name(), 10*time.Millisecond, jwsDec)
+	dt.Report(t, err == nil, "expected jti entry without exp to be rejected")
+
+	writeRevocations(file.Name(), []decoder.Revocation{{Subject: "mallory"}}, time.Now())
+	dec, err := decoder.NewRevocationDecoder(file.Name(), 10*time.Millisecond, jwsDec)
+	dt.HandleByPanic(err)
+	writeRevocations(file.Name(), []decoder.Revocation{{JwtID: "leaked"}}, time.Now().Add(time.Minute))
+	_, err = dec.Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{"sub": "mallory"})))
+	var revokedErr decoder.TokenRevokedError
+	dt.Report(t, !errors.As(err, &revokedErr), "expected the previous list to be kept got %v", err)
+}
+
+// waitFor polls the condition until it holds and fails the test if it doesn't within 5 seconds
+func waitFor(t *testing.T, condition func() bool, message string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func writeRevocations(path string, revocations []decoder.Revocation, modTime time.Time) {
+	data, err := json.Marshal(revocations)
+	dt.HandleByPanic(err)
+	dt.HandleByPanic(ioutil.WriteFile(path, data, 0600))
+	dt.HandleByPanic(os.Chtimes(path, modTime, modTime))
+}
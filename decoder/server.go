@@ -2,13 +2,14 @@ package decoder
 
 import (
 	"net/http"
-	"strings"
 
 	zLog "github.com/rs/zerolog/log"
 )
 
 const (
 	statusKey = "status"
+	reasonKey = "reason"
+	sourceKey = "source"
 )
 
 // Server is a http handler that will use a decoder to decode the authHeaderKey JWT-Token
@@ -18,45 +19,106 @@ type Server struct {
 	authHeaderKey           string
 	tokenValidatedHeaderKey string
 	authHeaderRequired      bool
+	validation              Validation
+	dpop                    *dpopVerifier
+	certificateBinding      bool
+	cookieNames             []string
+	sources                 []TokenSource
+	rejectAmbiguous         bool
+	claimFormats            map[string]ClaimFormat
 }
 
-// NewServer returns a new server that will decode the header with key authHeaderKey
+// ServerOption configures optional behaviour of the server
+type ServerOption func(s *Server)
+
+// WithValidation sets how the time claims of the decoded tokens are validated
+func WithValidation(v Validation) ServerOption {
+	return func(s *Server) {
+		s.validation = v
+	}
+}
+
+// NewServer returns a new server that will decode the bearer (or DPoP) token of the header with key authHeaderKey
 // with the given TokenDecoder decoder.
-func NewServer(decoder TokenDecoder, authHeaderKey, tokenValidatedHeaderKey string, authHeaderRequired bool) *Server {
-	return &Server{decoder: decoder, authHeaderKey: authHeaderKey, tokenValidatedHeaderKey: tokenValidatedHeaderKey, authHeaderRequired: authHeaderRequired}
+func NewServer(decoder TokenDecoder, authHeaderKey, tokenValidatedHeaderKey string, authHeaderRequired bool, opts ...ServerOption) *Server {
+	s := &Server{decoder: decoder, authHeaderKey: authHeaderKey, tokenValidatedHeaderKey: tokenValidatedHeaderKey, authHeaderRequired: authHeaderRequired}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.sources == nil {
+		s.sources = []TokenSource{HeaderSource(authHeaderKey, BearerScheme, DPoPScheme)}
+		for _, name := range s.cookieNames {
+			s.sources = append(s.sources, CookieSource(name))
+		}
+	}
+	return s
 }
 
 // DecodeToken http handler
 func (s *Server) DecodeToken(rw http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	log := zLog.Ctx(ctx)
-	if _, ok := r.Header[s.authHeaderKey]; !ok {
+	cred, ok, err := s.credential(r)
+	if err != nil {
+		s.reject(rw, r, err, "unable to extract token")
+		return
+	}
+	if !ok {
 		var status int
 		if s.authHeaderRequired {
 			status = http.StatusUnauthorized
-			log.Warn().Int(statusKey, status).Msgf("no auth header %s, early exit", s.authHeaderKey)
+			log.Warn().Int(statusKey, status).Msgf("no token in %v, early exit", s.sources)
 		} else {
 			status = http.StatusOK
 			rw.Header().Set(s.tokenValidatedHeaderKey, "false")
-			log.Debug().Int(statusKey, http.StatusOK).Str(s.tokenValidatedHeaderKey, "false").Msgf("no auth header %s, early exit", s.authHeaderKey)
+			log.Debug().Int(statusKey, http.StatusOK).Str(s.tokenValidatedHeaderKey, "false").Msgf("no token in %v, early exit", s.sources)
 		}
 		rw.WriteHeader(status)
 		return
 	}
-	authHeader := r.Header.Get(s.authHeaderKey)
-	t, err := s.decoder.Decode(ctx, strings.TrimPrefix(authHeader, "Bearer "))
+	logger := log.With().Str(sourceKey, cred.source).Logger()
+	log = &logger
+	ctx = log.WithContext(ctx)
+	r = r.WithContext(ctx)
+	raw, dpop := cred.token, cred.scheme == DPoPScheme
+	if dpop && s.dpop == nil {
+		s.reject(rw, r, InvalidCredentialsError{"the DPoP scheme is not enabled"}, "unable to extract token")
+		return
+	}
+	t, err := s.decoder.Decode(ctx, raw)
 	if err != nil {
-		log.Warn().Err(err).Int(statusKey, http.StatusUnauthorized).Msg("unable to decode token")
-		rw.WriteHeader(http.StatusUnauthorized)
+		s.reject(rw, r, err, "unable to decode token")
 		return
 	}
-	if err = t.Validate(); err != nil {
-		log.Warn().Err(err).Int(statusKey, http.StatusUnauthorized).Msg("unable to validate token")
-		rw.WriteHeader(http.StatusUnauthorized)
+	if err = t.Validate(s.validation); err != nil {
+		s.reject(rw, r, err, "unable to validate token")
 		return
 	}
+	if s.dpop != nil {
+		if err = s.dpop.verify(r, raw, t, dpop, s.validation.Leeway); err != nil {
+			s.reject(rw, r, err, "unable to verify DPoP proof")
+			return
+		}
+	}
+	if s.certificateBinding {
+		if err = verifyCertificateBinding(r, t); err != nil {
+			s.reject(rw, r, err, "unable to verify certificate binding")
+			return
+		}
+	}
 	le := log.Debug()
 	for k, v := range t.Claims {
+		if values, ok := s.render(t, k); ok {
+			for _, value := range values {
+				rw.Header().Add(k, value)
+			}
+			le.Strs(k, values)
+			continue
+		}
+		rw.Header().Set(k, v)
+		le.Str(k, v)
+	}
+	for k, v := range cred.responseHeaders {
 		rw.Header().Set(k, v)
 		le.Str(k, v)
 	}
@@ -66,3 +128,28 @@ func (s *Server) DecodeToken(rw http.ResponseWriter, r *http.Request) {
 	rw.WriteHeader(http.StatusOK)
 	return
 }
+
+// render returns the header values of the claim of the header with its format and false if it has no format,
+// the formats of the decoder of the token take precedence over the formats of the server
+func (s *Server) render(t *Token, header string) ([]string, bool) {
+	formats := s.claimFormats
+	if t.formats != nil {
+		formats = t.formats
+	}
+	format, ok := formats[header]
+	if !ok {
+		return nil, false
+	}
+	value, ok := t.values[header]
+	if !ok {
+		return nil, false
+	}
+	return format.render(value)
+}
+
+func (s *Server) reject(rw http.ResponseWriter, r *http.Request, err error, msg string) {
+	reason := rejectionReason(err)
+	rejectedTokens.WithLabelValues(reason).Inc()
+	zLog.Ctx(r.Context()).Warn().Err(err).Str(reasonKey, reason).Int(statusKey, http.StatusUnauthorized).Msg(msg)
+	rw.WriteHeader(http.StatusUnauthorized)
+}
@@ -113,10 +113,38 @@ func TestServerResponseHeaders(t *testing.T) {
 	})(t)
 }
 
+func TestServerRejectsDisallowedIssuer(t *testing.T) {
+	tc := dt.NewTest()
+	tests := map[string]struct {
+		token []byte
+		code  int
+	}{
+		"Allowed issuer":    {token: rndTokenWith(tc, "iss", "https://allowed.issuer"), code: http.StatusOK},
+		"Disallowed issuer": {token: rndTokenWith(tc, "iss", "https://other.issuer"), code: http.StatusUnauthorized},
+		"Missing issuer":    {token: validRndToken(tc), code: http.StatusUnauthorized},
+	}
+	for name, test := range tests {
+		t.Run(name, serverTestWith(tc, func(srv *decoder.Server) func(*testing.T) {
+			return func(t *testing.T) {
+				for i := 0; i < 2; i++ {
+					rr, req := reqFor(test.token)
+					srv.DecodeToken(rr, req)
+					status := rr.Result().StatusCode
+					dt.Report(t, status != test.code, "incorrect server response on request %d, %d, expected: %d", i, status, test.code)
+				}
+			}
+		}, decoder.WithIssuers("https://allowed.issuer")))
+	}
+}
+
 func serverTest(tc *dt.TestConfig, subTest func(s *decoder.Server) func(t *testing.T)) func(t *testing.T) {
+	return serverTestWith(tc, subTest)
+}
+
+func serverTestWith(tc *dt.TestConfig, subTest func(s *decoder.Server) func(t *testing.T), opts ...decoder.JwsDecoderOption) func(t *testing.T) {
 	return func(t *testing.T) {
-		t.Run("unCachedServer", subTest(tc.UncachedServer(claimMappings)))
-		t.Run("cachedServer", subTest(tc.CachedServer(claimMappings)))
+		t.Run("unCachedServer", subTest(tc.UncachedServer(claimMappings, opts...)))
+		t.Run("cachedServer", subTest(tc.CachedServer(claimMappings, opts...)))
 	}
 }
 
@@ -183,6 +211,12 @@ func expiredRndToken(tc *dt.TestConfig) []byte {
 	return tc.NewExpiredToken(newRndClaims())
 }
 
+func rndTokenWith(tc *dt.TestConfig, claim string, value interface{}) []byte {
+	claims := newRndClaims()
+	claims[claim] = value
+	return tc.NewValidToken(claims)
+}
+
 func newRndClaims() map[string]interface{} {
 	return newClaims(strconv.FormatInt(rnd.Int63(), 10))
 }
@@ -0,0 +1,189 @@
+package decoder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/rs/zerolog/log"
+)
+
+const staleRetryInterval = time.Second
+
+// DefaultSnapshotMaxStaleness is the maximum staleness of the keys with a snapshot dir but without WithMaxStaleness
+const DefaultSnapshotMaxStaleness = 24 * time.Hour
+
+// StaleKeysError is thrown if the keys could not be refreshed for longer than the maximum staleness
+type StaleKeysError struct {
+	jwksURL   string
+	fetchedAt time.Time
+}
+
+func (e StaleKeysError) Error() string {
+	return fmt.Sprintf("keys of %s are stale (last fetched at: %s)", e.jwksURL, e.fetchedAt.Format(time.RFC3339))
+}
+
+// Temporary returns true as the keys might be refreshed on the next request
+func (e StaleKeysError) Temporary() bool {
+	return true
+}
+
+// WithSnapshotDir persists the last fetched JWKS in the directory, the snapshot is used if the JWKS can't be
+// fetched on startup for at most the maximum staleness (DefaultSnapshotMaxStaleness without WithMaxStaleness)
+func WithSnapshotDir(dir string) RemoteKeySourceOption {
+	return func(s *remoteKeySource) {
+		s.snapshotDir = dir
+	}
+}
+
+// WithMaxStaleness keeps using the last fetched keys (or the snapshot) while the JWKS can't be refreshed
+// for at most maxStaleness since they were fetched, after that tokens are rejected until the JWKS can be fetched again
+func WithMaxStaleness(maxStaleness time.Duration) RemoteKeySourceOption {
+	return func(s *remoteKeySource) {
+		s.maxStaleness = maxStaleness
+	}
+}
+
+// WithRemoteKeySourceOptions configures the remote key source created by NewJwsDecoder
+func WithRemoteKeySourceOptions(opts ...RemoteKeySourceOption) JwsDecoderOption {
+	return func(d *jwsDecoder) {
+		d.remoteOpts = append(d.remoteOpts, opts...)
+	}
+}
+
+func (s *remoteKeySource) tracksStaleness() bool {
+	return s.snapshotDir != "" || s.maxStaleness > 0
+}
+
+// lastGoodKeys returns the fetched keys or the last good keys if the fetch or the last refresh failed,
+// as long as they are not older than the maximum staleness
+func (s *remoteKeySource) lastGoodKeys(ctx context.Context, keys jwk.Set, err error) (jwk.Set, error) {
+	keys, updated, err := s.updateKeys(ctx, keys, err)
+	if updated {
+		s.saveSnapshot()
+	}
+	return keys, err
+}
+
+// updateKeys remembers the fetched keys and returns true if they have changed, or the last good keys if the
+// fetch or the last refresh failed
+func (s *remoteKeySource) updateKeys(ctx context.Context, keys jwk.Set, err error) (jwk.Set, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// the fetcher replaces the set on every successful refresh and keeps the old set if it fails
+	updated := err == nil && keys != s.keys
+	if updated {
+		s.keys, s.fetchedAt, s.failing = keys, time.Now(), false
+		keysFetchedAt.WithLabelValues(s.jwksURL).Set(float64(s.fetchedAt.Unix()))
+	}
+	stale := err != nil || s.failing
+	if s.keys == nil {
+		return nil, updated, err
+	}
+	if stale {
+		staleKeys.WithLabelValues(s.jwksURL).Set(1)
+		if s.maxStaleness > 0 && time.Since(s.fetchedAt) > s.maxStaleness {
+			return nil, updated, StaleKeysError{jwksURL: s.jwksURL, fetchedAt: s.fetchedAt}
+		}
+		log.Ctx(ctx).Debug().Err(err).Str("jwks", s.jwksURL).Time("fetchedAt", s.fetchedAt).Msg("using stale keys")
+	} else {
+		staleKeys.WithLabelValues(s.jwksURL).Set(0)
+	}
+	return s.keys, updated, nil
+}
+
+// retryDue returns true at most once every staleRetryInterval, the fetcher only retries failed refreshes
+// after its refresh interval so the source retries itself once the keys are too stale to be used
+func (s *remoteKeySource) retryDue() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if time.Since(s.lastRetry) < staleRetryInterval {
+		return false
+	}
+	s.lastRetry = time.Now()
+	return true
+}
+
+// watchErrors marks the keys as stale when a background refresh fails until the context is done
+func (s *remoteKeySource) watchErrors(ctx context.Context, errs <-chan jwk.AutoRefreshError) {
+	for {
+		select {
+		case err := <-errs:
+			log.Warn().Err(err.Error).Str("jwks", err.URL).Msg("unable to refresh keys")
+			s.mutex.Lock()
+			s.failing = true
+			s.mutex.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *remoteKeySource) snapshotPath() string {
+	hash := sha256.Sum256([]byte(s.jwksURL))
+	return filepath.Join(s.snapshotDir, hex.EncodeToString(hash[:8])+".json")
+}
+
+// loadSnapshot loads the last persisted keys, the modification time of the snapshot is when they were fetched
+func (s *remoteKeySource) loadSnapshot() {
+	if s.snapshotDir == "" {
+		return
+	}
+	path := s.snapshotPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("unable to read keys snapshot")
+		return
+	}
+	keys, err := jwk.Parse(data)
+	if err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("unable to parse keys snapshot")
+		return
+	}
+	s.mutex.Lock()
+	s.keys, s.fetchedAt = keys, info.ModTime()
+	s.mutex.Unlock()
+	s.snapshotMutex.Lock()
+	s.savedKeys = keys
+	s.snapshotMutex.Unlock()
+	keysFetchedAt.WithLabelValues(s.jwksURL).Set(float64(s.fetchedAt.Unix()))
+	log.Info().Str("jwks", s.jwksURL).Str("path", path).Time("fetchedAt", s.fetchedAt).Msg("loaded keys snapshot")
+}
+
+// saveSnapshot persists the current keys unless they have been saved already, the snapshot is written to a
+// temporary file first to never leave a partial snapshot. The key source mutex is not held while writing
+func (s *remoteKeySource) saveSnapshot() {
+	if s.snapshotDir == "" {
+		return
+	}
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	s.mutex.Lock()
+	keys := s.keys
+	s.mutex.Unlock()
+	if keys == nil || keys == s.savedKeys {
+		return
+	}
+	path := s.snapshotPath()
+	data, err := json.Marshal(keys)
+	if err == nil {
+		if err = os.WriteFile(path+".tmp", data, 0600); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("unable to save keys snapshot")
+		return
+	}
+	s.savedKeys = keys
+}
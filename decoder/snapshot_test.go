@@ -0,0 +1,118 @@
+package decoder_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func TestKeySnapshotOnStartup(t *testing.T) {
+	tc := dt.NewTest()
+	dir, err := ioutil.TempDir(".", "snapshots")
+	dt.HandleByPanic(err)
+	defer os.RemoveAll(dir)
+	jwks := &rotatingJwksServer{jwks: tc.JWKS()}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	_, err = decoder.NewRemoteKeySource(server.URL, decoder.WithSnapshotDir(dir))
+	dt.Report(t, err != nil, "unable to fetch keys %s", err)
+
+	jwks.setDown(true)
+	keys, err := decoder.NewRemoteKeySource(server.URL, decoder.WithSnapshotDir(dir), decoder.WithMaxStaleness(time.Hour))
+	dt.Report(t, err != nil, "expected the snapshot to be used while the jwks is down got %v", err)
+	_, err = decoder.NewKeySourceDecoder(keys, make(map[string]string)).Decode(dt.Ctx(), string(tc.NewValidToken(map[string]interface{}{})))
+	dt.Report(t, err != nil, "unable to decode token with snapshot keys %s", err)
+
+	snapshots, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, snapshot := range snapshots {
+		dt.HandleByPanic(os.Chtimes(snapshot, time.Now(), time.Now().Add(-2*time.Hour)))
+	}
+	_, err = decoder.NewRemoteKeySource(server.URL, decoder.WithSnapshotDir(dir), decoder.WithMaxStaleness(time.Hour))
+	var staleKeysErr decoder.StaleKeysError
+	dt.Report(t, !errors.As(err, &staleKeysErr), "expected StaleKeysError for old snapshot got %v", err)
+
+	for _, snapshot := range snapshots {
+		dt.HandleByPanic(os.Chtimes(snapshot, time.Now(), time.Now().Add(-decoder.DefaultSnapshotMaxStaleness-time.Hour)))
+	}
+	_, err = decoder.NewRemoteKeySource(server.URL, decoder.WithSnapshotDir(dir))
+	dt.Report(t, !errors.As(err, &staleKeysErr), "expected StaleKeysError for snapshot older than the default staleness got %v", err)
+}
+
+func TestStaleKeysWhenRefreshFails(t *testing.T) {
+	tc := dt.NewTest()
+	jwks := &rotatingJwksServer{jwks: tc.JWKS()}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	keys, err := decoder.NewRemoteKeySource(server.URL, decoder.WithMaxStaleness(100*time.Millisecond))
+	dt.HandleByPanic(err)
+	dec := decoder.NewKeySourceDecoder(keys, make(map[string]string), decoder.WithKeyRefresh(time.Millisecond))
+	token := string(tc.NewValidToken(map[string]interface{}{}))
+
+	jwks.setDown(true)
+	_, err = dec.Decode(dt.Ctx(), string(tc.NewTokenWithHeaders(map[string]interface{}{}, map[string]interface{}{"kid": "unknown"})))
+	dt.Report(t, err == nil, "expected token with unknown kid to be rejected")
+	time.Sleep(10 * time.Millisecond)
+	_, err = dec.Decode(dt.Ctx(), token)
+	dt.Report(t, err != nil, "expected stale keys to be used after a failed refresh got %v", err)
+
+	time.Sleep(150 * time.Millisecond)
+	_, err = dec.Decode(dt.Ctx(), token)
+	var staleKeysErr decoder.StaleKeysError
+	dt.Report(t, !errors.As(err, &staleKeysErr), "expected StaleKeysError after max staleness got %v", err)
+
+	jwks.setDown(false)
+	time.Sleep(time.Second)
+	_, err = dec.Decode(dt.Ctx(), token)
+	dt.Report(t, err != nil, "expected keys to be fresh after a successful refresh got %v", err)
+}
+
+func TestNoBlockingFetchAfterFailedStartup(t *testing.T) {
+	before, after := dt.NewTest(), dt.NewTest()
+	dir, err := ioutil.TempDir(".", "snapshots")
+	dt.HandleByPanic(err)
+	defer os.RemoveAll(dir)
+	jwks := &rotatingJwksServer{jwks: before.JWKS()}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	_, err = decoder.NewRemoteKeySource(server.URL, decoder.WithSnapshotDir(dir))
+	dt.HandleByPanic(err)
+	tests := map[string][]decoder.RemoteKeySourceOption{
+		"Snapshot":   {decoder.WithSnapshotDir(dir)},
+		"NoSnapshot": nil,
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			jwks.rotate(before.JWKS())
+			jwks.setDown(true)
+			jwks.setDelay(300 * time.Millisecond)
+			keys, _ := decoder.NewRemoteKeySource(server.URL, opts...)
+			dec := decoder.NewCachedJwtDecoder(dt.Cache, decoder.NewKeySourceDecoder(keys, make(map[string]string)))
+			for i := 0; i < 3; i++ {
+				start := time.Now()
+				// the subtests share the cache so each decodes its own token
+				_, err := dec.Decode(dt.Ctx(), string(before.NewValidToken(map[string]interface{}{"sub": name})))
+				dt.Report(t, time.Since(start) > 100*time.Millisecond, "expected keys without waiting for the jwks took %s", time.Since(start))
+				var keySourceErr decoder.KeySourceError
+				dt.Report(t, opts != nil && err != nil, "expected the snapshot to be used got %v", err)
+				dt.Report(t, opts == nil && !errors.As(err, &keySourceErr), "expected KeySourceError without snapshot got %v", err)
+			}
+
+			jwks.rotate(after.JWKS())
+			jwks.setDown(false)
+			jwks.setDelay(0)
+			token := string(after.NewValidToken(map[string]interface{}{}))
+			deadline := time.Now().Add(3 * time.Second)
+			for _, err = dec.Decode(dt.Ctx(), token); err != nil && time.Now().Before(deadline); _, err = dec.Decode(dt.Ctx(), token) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			dt.Report(t, err != nil, "expected the keys to be fetched in the background got %v", err)
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package decoder
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// BearerScheme is the authorization scheme of bearer tokens
+const BearerScheme = "Bearer"
+
+// InvalidCredentialsError is thrown if a token source of the request is malformed, like a header with an
+// unexpected authorization scheme
+type InvalidCredentialsError struct {
+	reason string
+}
+
+func (e InvalidCredentialsError) Error() string {
+	return fmt.Sprintf("invalid credentials: %s", e.reason)
+}
+
+// AmbiguousCredentialsError is thrown if a token source is set more than once or several token sources
+// of the request carry different tokens
+type AmbiguousCredentialsError struct {
+	reason string
+}
+
+func (e AmbiguousCredentialsError) Error() string {
+	return fmt.Sprintf("ambiguous credentials: %s", e.reason)
+}
+
+// credential is a token found in a request
+type credential struct {
+	token           string
+	scheme          string
+	source          string
+	responseHeaders map[string]string
+}
+
+// TokenSource extracts the token from a request
+type TokenSource interface {
+	// credential returns the token of the request and false if the source is not set
+	credential(r *http.Request) (credential, bool, error)
+	String() string
+}
+
+// WithTokenSources replaces the auth header (and cookies) as token sources with the sources, the sources are checked
+// in order and the request is rejected if more than one source carries a token unless they are the same token.
+// Without token sources the auth header takes precedence over the cookies and the first source that is set is used
+func WithTokenSources(sources ...TokenSource) ServerOption {
+	return func(s *Server) {
+		s.sources, s.rejectAmbiguous = sources, true
+	}
+}
+
+type headerSource struct {
+	name    string
+	schemes []string
+}
+
+// HeaderSource returns a TokenSource for the header which has to use one of the authorization schemes
+// (case-insensitive), the header value is the token if there are no schemes
+func HeaderSource(name string, schemes ...string) TokenSource {
+	return &headerSource{name: http.CanonicalHeaderKey(name), schemes: schemes}
+}
+
+func (s *headerSource) credential(r *http.Request) (credential, bool, error) {
+	values := r.Header.Values(s.name)
+	if len(values) == 0 {
+		return credential{}, false, nil
+	}
+	if len(values) > 1 {
+		return credential{}, false, AmbiguousCredentialsError{fmt.Sprintf("%d %s headers", len(values), s.name)}
+	}
+	if len(s.schemes) == 0 {
+		if values[0] == "" {
+			return credential{}, false, InvalidCredentialsError{fmt.Sprintf("empty %s header", s.name)}
+		}
+		return credential{token: values[0], source: s.String()}, true, nil
+	}
+	ind := strings.Index(values[0], " ")
+	if ind == -1 {
+		return credential{}, false, InvalidCredentialsError{fmt.Sprintf("%s header has no authorization scheme", s.name)}
+	}
+	scheme, token := values[0][:ind], strings.TrimLeft(values[0][ind+1:], " ")
+	for _, expected := range s.schemes {
+		if strings.EqualFold(scheme, expected) {
+			if token == "" {
+				return credential{}, false, InvalidCredentialsError{fmt.Sprintf("%s header has no token", s.name)}
+			}
+			return credential{token: token, scheme: expected, source: s.String()}, true, nil
+		}
+	}
+	return credential{}, false, InvalidCredentialsError{fmt.Sprintf("%s header uses the unexpected scheme '%s'", s.name, scheme)}
+}
+
+func (s *headerSource) String() string {
+	return "header:" + s.name
+}
+
+type querySource struct {
+	param string
+}
+
+// QuerySource returns a TokenSource for the query parameter of the original request (X-Forwarded-Uri)
+func QuerySource(param string) TokenSource {
+	return &querySource{param: param}
+}
+
+func (s *querySource) credential(r *http.Request) (credential, bool, error) {
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return credential{}, false, InvalidCredentialsError{fmt.Sprintf("unable to parse forwarded uri: %s", err)}
+	}
+	values := parsed.Query()[s.param]
+	if len(values) == 0 {
+		return credential{}, false, nil
+	}
+	if len(values) > 1 {
+		return credential{}, false, AmbiguousCredentialsError{fmt.Sprintf("%d %s query parameters", len(values), s.param)}
+	}
+	if values[0] == "" {
+		return credential{}, false, InvalidCredentialsError{fmt.Sprintf("empty %s query parameter", s.param)}
+	}
+	return credential{token: values[0], source: s.String()}, true, nil
+}
+
+func (s *querySource) String() string {
+	return "query:" + s.param
+}
+
+// credential returns the token of the first token source that is set, with explicit token sources
+// requests with different tokens in several sources are rejected
+func (s *Server) credential(r *http.Request) (credential, bool, error) {
+	var found credential
+	ok := false
+	for _, source := range s.sources {
+		c, set, err := source.credential(r)
+		if err != nil {
+			return credential{}, false, err
+		}
+		if !set {
+			continue
+		}
+		if !ok {
+			found, ok = c, true
+			if !s.rejectAmbiguous {
+				break
+			}
+			continue
+		}
+		if c.token != found.token {
+			return credential{}, false, AmbiguousCredentialsError{fmt.Sprintf("different tokens in %s and %s", found.source, c.source)}
+		}
+		if found.responseHeaders == nil {
+			found.responseHeaders = c.responseHeaders
+		}
+	}
+	return found, ok, nil
+}
@@ -0,0 +1,60 @@
+package decoder_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func TestTokenSources(t *testing.T) {
+	tc := dt.NewTest()
+	jwsDec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string))
+	srv := decoder.NewServer(jwsDec, dt.AuthHeaderKey, dt.TokenValidatedHeaderKey, false, decoder.WithTokenSources(
+		decoder.HeaderSource("Authorization", decoder.BearerScheme),
+		decoder.CookieSource("access_token"),
+		decoder.QuerySource("access_token"),
+		decoder.HeaderSource("Proxy-Authorization", decoder.BearerScheme),
+		decoder.HeaderSource("X-Api-Token"),
+	))
+	valid := string(tc.NewValidToken(map[string]interface{}{}))
+	other := string(tc.NewValidToken(map[string]interface{}{"sub": "other"}))
+	tests := []struct {
+		name      string
+		headers   http.Header
+		code      int
+		validated string
+	}{
+		{name: "Bearer", headers: http.Header{"Authorization": {"Bearer " + valid}}, code: http.StatusOK, validated: "true"},
+		{name: "LowercaseScheme", headers: http.Header{"Authorization": {"bearer " + valid}}, code: http.StatusOK, validated: "true"},
+		{name: "BasicScheme", headers: http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}}, code: http.StatusUnauthorized},
+		{name: "NoScheme", headers: http.Header{"Authorization": {valid}}, code: http.StatusUnauthorized},
+		{name: "NoToken", headers: http.Header{"Authorization": {"Bearer "}}, code: http.StatusUnauthorized},
+		{name: "DPoPNotEnabled", headers: http.Header{"Authorization": {"DPoP " + valid}}, code: http.StatusUnauthorized},
+		{name: "DuplicateHeader", headers: http.Header{"Authorization": {"Bearer " + valid, "Bearer " + valid}}, code: http.StatusUnauthorized},
+		{name: "Cookie", headers: http.Header{"Cookie": {"access_token=" + valid}}, code: http.StatusOK, validated: "true"},
+		{name: "DuplicateCookie", headers: http.Header{"Cookie": {fmt.Sprintf("access_token=%s; access_token=%s", valid, valid)}}, code: http.StatusUnauthorized},
+		{name: "Query", headers: http.Header{"X-Forwarded-Uri": {"/path?access_token=" + valid}}, code: http.StatusOK, validated: "true"},
+		{name: "DuplicateQuery", headers: http.Header{"X-Forwarded-Uri": {fmt.Sprintf("/path?access_token=%s&access_token=%s", valid, valid)}}, code: http.StatusUnauthorized},
+		{name: "ProxyAuthorization", headers: http.Header{"Proxy-Authorization": {"Bearer " + valid}}, code: http.StatusOK, validated: "true"},
+		{name: "HeaderWithoutScheme", headers: http.Header{"X-Api-Token": {valid}}, code: http.StatusOK, validated: "true"},
+		{name: "SameTokenTwice", headers: http.Header{"Authorization": {"Bearer " + valid}, "X-Forwarded-Uri": {"/?access_token=" + valid}}, code: http.StatusOK, validated: "true"},
+		{name: "DifferentTokens", headers: http.Header{"Authorization": {"Bearer " + valid}, "Proxy-Authorization": {"Bearer " + other}}, code: http.StatusUnauthorized},
+		{name: "NoSource", headers: http.Header{"X-Forwarded-Uri": {"/path?other=" + valid}}, code: http.StatusOK, validated: "false"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(dt.Ctx(), "GET", "/", nil)
+			req.Header = test.headers
+			rr := httptest.NewRecorder()
+			srv.DecodeToken(rr, req)
+			status := rr.Result().StatusCode
+			dt.Report(t, status != test.code, "incorrect server response, %d, expected: %d", status, test.code)
+			validated := rr.Result().Header.Get(dt.TokenValidatedHeaderKey)
+			dt.Report(t, validated != test.validated, "header '%s' is '%s', expected: %s", dt.TokenValidatedHeaderKey, validated, test.validated)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package decoder
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const webSocketProtocolHeader = "Sec-WebSocket-Protocol"
+
+type webSocketSource struct {
+	marker            string
+	protocolHeaderKey string
+}
+
+// WebSocketProtocolSource returns a TokenSource for WebSocket upgrade requests that offer the token as the subprotocol
+// after the marker (`Sec-WebSocket-Protocol: <marker>, <token>`). The subprotocol the upstream has to echo back is set
+// in the protocolHeaderKey response header, which is the first other offered subprotocol or else the marker
+func WebSocketProtocolSource(marker, protocolHeaderKey string) TokenSource {
+	return &webSocketSource{marker: marker, protocolHeaderKey: protocolHeaderKey}
+}
+
+func (s *webSocketSource) credential(r *http.Request) (credential, bool, error) {
+	if !isWebSocketUpgrade(r) {
+		return credential{}, false, nil
+	}
+	var protocols []string
+	for _, value := range r.Header.Values(webSocketProtocolHeader) {
+		for _, protocol := range strings.Split(value, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	ind := -1
+	for i, protocol := range protocols {
+		if protocol != s.marker {
+			continue
+		}
+		if ind != -1 {
+			return credential{}, false, AmbiguousCredentialsError{fmt.Sprintf("subprotocol %s is offered more than once", s.marker)}
+		}
+		ind = i
+	}
+	if ind == -1 {
+		return credential{}, false, nil
+	}
+	if ind == len(protocols)-1 {
+		return credential{}, false, InvalidCredentialsError{fmt.Sprintf("no token after subprotocol %s", s.marker)}
+	}
+	echo := s.marker
+	for i, protocol := range protocols {
+		if i != ind && i != ind+1 {
+			echo = protocol
+			break
+		}
+	}
+	return credential{token: protocols[ind+1], source: s.String(), responseHeaders: map[string]string{s.protocolHeaderKey: echo}}, true, nil
+}
+
+func (s *webSocketSource) String() string {
+	return "websocket:" + s.marker
+}
+
+// isWebSocketUpgrade returns true for WebSocket opening handshakes, the Upgrade header is hop-by-hop
+// and might not be forwarded so the Sec-WebSocket-Key is also accepted
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Key") != ""
+}
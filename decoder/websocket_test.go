@@ -0,0 +1,52 @@
+package decoder_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SimonSchneider/traefik-jwt-decode/decoder"
+	dt "github.com/SimonSchneider/traefik-jwt-decode/decodertest"
+)
+
+func TestWebSocketProtocolSource(t *testing.T) {
+	tc := dt.NewTest()
+	jwsDec, _ := decoder.NewJwsDecoder(tc.JwksURL, make(map[string]string))
+	srv := decoder.NewServer(jwsDec, dt.AuthHeaderKey, dt.TokenValidatedHeaderKey, false, decoder.WithTokenSources(
+		decoder.HeaderSource("Authorization", decoder.BearerScheme),
+		decoder.WebSocketProtocolSource("access_token", "jwt-token-websocket-protocol"),
+	))
+	valid := string(tc.NewValidToken(map[string]interface{}{}))
+	invalid := string(tc.NewInvalidToken(map[string]interface{}{}))
+	upgrade := func(protocols ...string) http.Header {
+		return http.Header{"Upgrade": {"websocket"}, "Sec-Websocket-Key": {"dGhlIHNhbXBsZSBub25jZQ=="}, "Sec-Websocket-Protocol": protocols}
+	}
+	tests := map[string]struct {
+		headers  http.Header
+		code     int
+		protocol string
+	}{
+		"Token":              {headers: upgrade("access_token, " + valid), code: http.StatusOK, protocol: "access_token"},
+		"OtherProtocol":      {headers: upgrade("graphql-ws, access_token, " + valid), code: http.StatusOK, protocol: "graphql-ws"},
+		"SeparateHeaders":    {headers: upgrade("access_token", valid, "graphql-ws"), code: http.StatusOK, protocol: "graphql-ws"},
+		"InvalidToken":       {headers: upgrade("access_token, " + invalid), code: http.StatusUnauthorized},
+		"MissingToken":       {headers: upgrade("graphql-ws, access_token"), code: http.StatusUnauthorized},
+		"DuplicateMarker":    {headers: upgrade("access_token, " + valid + ", access_token, " + valid), code: http.StatusUnauthorized},
+		"NoMarker":           {headers: upgrade("graphql-ws"), code: http.StatusOK},
+		"NoUpgrade":          {headers: http.Header{"Sec-Websocket-Protocol": {"access_token, " + valid}}, code: http.StatusOK},
+		"SameTokenInHeader":  {headers: http.Header{"Authorization": {"Bearer " + valid}, "Sec-Websocket-Key": {"a2V5"}, "Sec-Websocket-Protocol": {"access_token, " + valid}}, code: http.StatusOK, protocol: "access_token"},
+		"OtherTokenInHeader": {headers: http.Header{"Authorization": {"Bearer " + invalid}, "Sec-Websocket-Key": {"a2V5"}, "Sec-Websocket-Protocol": {"access_token, " + valid}}, code: http.StatusUnauthorized},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequestWithContext(dt.Ctx(), "GET", "/", nil)
+			req.Header = test.headers
+			rr := httptest.NewRecorder()
+			srv.DecodeToken(rr, req)
+			status := rr.Result().StatusCode
+			dt.Report(t, status != test.code, "incorrect server response, %d, expected: %d", status, test.code)
+			protocol := rr.Result().Header.Get("jwt-token-websocket-protocol")
+			dt.Report(t, protocol != test.protocol, "subprotocol to echo is '%s', expected: '%s'", protocol, test.protocol)
+		})
+	}
+}
@@ -2,13 +2,25 @@ package decodertest
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	mrand "math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
@@ -20,6 +32,7 @@ import (
 
 	"github.com/dgraph-io/ristretto"
 	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwe"
 	"github.com/lestrrat-go/jwx/jwk"
 	"github.com/lestrrat-go/jwx/jws"
 	"github.com/lestrrat-go/jwx/jwt"
@@ -47,8 +60,12 @@ var (
 // TestConfig holds most config used for tests also starts a JWKS server
 type TestConfig struct {
 	// JwksURL is where the JWKS is hosted
-	JwksURL    string
+	JwksURL string
+	// IssuerURL is the issuer of the OpenID discovery document hosted next to the JWKS
+	IssuerURL  string
 	privateKey *rsa.PrivateKey
+	jwks       jwk.Set
+	kid        string
 	opts       jws.SignOption
 }
 
@@ -57,14 +74,28 @@ func NewTest() *TestConfig {
 	var jwkKey jwk.Key
 	tc := &TestConfig{}
 	tc.privateKey, jwkKey = generateKey()
-	jwkKeyID := jwkKey.KeyID()
-	tc.opts = options(jwkKeyID)
-	jwks := jwk.NewSet()
-	jwks.Add(jwkKey)
-	tc.JwksURL = startJwksServer(jwks)
+	tc.kid = jwkKey.KeyID()
+	tc.opts = options(tc.kid)
+	tc.jwks = jwk.NewSet()
+	tc.jwks.Add(jwkKey)
+	tc.IssuerURL, tc.JwksURL = startJwksServer(tc.jwks)
 	return tc
 }
 
+// JWKS returns the JSON encoded JWKS served at JwksURL
+func (tc *TestConfig) JWKS() []byte {
+	keys, err := json.Marshal(tc.jwks)
+	HandleByPanic(err)
+	return keys
+}
+
+// PublicKeyPEM returns the PEM encoded public key of the key in the JWKS
+func (tc *TestConfig) PublicKeyPEM() []byte {
+	der, err := x509.MarshalPKIXPublicKey(&tc.privateKey.PublicKey)
+	HandleByPanic(err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
 func options(kid string) jws.SignOption {
 	h := jws.NewHeaders()
 	h.Set(jws.TypeKey, "JWT")
@@ -83,21 +114,28 @@ func generateKey() (*rsa.PrivateKey, jwk.Key) {
 	return privKey, jwkKey
 }
 
-func startJwksServer(jwks jwk.Set) string {
+func startJwksServer(jwks jwk.Set) (string, string) {
 	keys, err := json.Marshal(jwks)
 	HandleByPanic(err)
 	listener, err := net.Listen("tcp", ":0")
 	HandleByPanic(err)
 	path := "/.well-known/jwks.json"
+	issuerURL := fmt.Sprintf("http://0.0.0.0:%d", listener.Addr().(*net.TCPAddr).Port)
+	discovery, err := json.Marshal(decoder.Discovery{Issuer: issuerURL, JwksURI: issuerURL + path})
+	HandleByPanic(err)
 	go func() {
 		mux := http.NewServeMux()
 		mux.HandleFunc(path, func(rw http.ResponseWriter, r *http.Request) {
 			rw.WriteHeader(http.StatusOK)
 			rw.Write(keys)
 		})
+		mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, r *http.Request) {
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(discovery)
+		})
 		panic(http.Serve(listener, mux))
 	}()
-	return fmt.Sprintf("http://0.0.0.0:%d%s", listener.Addr().(*net.TCPAddr).Port, path)
+	return issuerURL, issuerURL + path
 }
 
 // NewValidToken generates a signed valid token with the given claims
@@ -116,7 +154,41 @@ func (tc *TestConfig) NewInvalidToken(claims map[string]interface{}) []byte {
 	return tc.newSignedToken(claims, time.Now().Add(time.Hour*24), privKey)
 }
 
+// NewTokenSignedWith generates a valid token signed with the given algorithm and key with the kid of the JWKS key
+func (tc *TestConfig) NewTokenSignedWith(claims map[string]interface{}, alg jwa.SignatureAlgorithm, key interface{}) []byte {
+	return tc.newSignedTokenWith(claims, time.Now().Add(time.Hour*24), alg, key)
+}
+
+// NewHmacToken generates a valid token with the given claims signed with the HMAC secret with the given kid
+func NewHmacToken(claims map[string]interface{}, alg jwa.SignatureAlgorithm, kid string, secret []byte) []byte {
+	return (&TestConfig{opts: options(kid)}).NewTokenSignedWith(claims, alg, secret)
+}
+
+// Encrypt wraps the signed token in a JWE encrypted with the given algorithm for the public key
+func Encrypt(token []byte, alg jwa.KeyEncryptionAlgorithm, publicKey interface{}) []byte {
+	h := jwe.NewHeaders()
+	h.Set(jwe.ContentTypeKey, "JWT")
+	encrypted, err := jwe.Encrypt(token, alg, publicKey, jwa.A256GCM, jwa.NoCompress, jwe.WithProtectedHeaders(h))
+	HandleByPanic(err)
+	return encrypted
+}
+
+// NewTokenWithHeaders generates a valid token with the given claims and additional JOSE headers
+func (tc *TestConfig) NewTokenWithHeaders(claims map[string]interface{}, headers map[string]interface{}) []byte {
+	h := jws.NewHeaders()
+	h.Set(jws.TypeKey, "JWT")
+	h.Set(jws.KeyIDKey, tc.kid)
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	return tc.newSignedTokenWith(claims, time.Now().Add(time.Hour*24), jwa.RS256, tc.privateKey, jws.WithHeaders(h))
+}
+
 func (tc *TestConfig) newSignedToken(claims map[string]interface{}, exp time.Time, key *rsa.PrivateKey) []byte {
+	return tc.newSignedTokenWith(claims, exp, jwa.RS256, key)
+}
+
+func (tc *TestConfig) newSignedTokenWith(claims map[string]interface{}, exp time.Time, alg jwa.SignatureAlgorithm, key interface{}, opts ...jws.SignOption) []byte {
 	t := jwt.New()
 	for k, v := range claims {
 		t.Set(k, v)
@@ -124,30 +196,33 @@ func (tc *TestConfig) newSignedToken(claims map[string]interface{}, exp time.Tim
 	t.Set(jwt.ExpirationKey, exp)
 	buf, err := json.MarshalIndent(t, "", "  ")
 	HandleByPanic(err)
-	token, err := jws.Sign(buf, jwa.RS256, key, tc.opts)
+	if len(opts) == 0 {
+		opts = append(opts, tc.opts)
+	}
+	token, err := jws.Sign(buf, alg, key, opts...)
 	HandleByPanic(err)
 	return token
 }
 
-func (tc *TestConfig) newJwsDecoder(claimMappings map[string]string) decoder.TokenDecoder {
-	d, err := decoder.NewJwsDecoder(tc.JwksURL, claimMappings)
+func (tc *TestConfig) newJwsDecoder(claimMappings map[string]string, opts ...decoder.JwsDecoderOption) decoder.TokenDecoder {
+	d, err := decoder.NewJwsDecoder(tc.JwksURL, claimMappings, opts...)
 	HandleByPanic(err)
 	return d
 }
 
-func (tc *TestConfig) newCachedDecoder(claimMappings map[string]string) decoder.TokenDecoder {
-	d := tc.newJwsDecoder(claimMappings)
+func (tc *TestConfig) newCachedDecoder(claimMappings map[string]string, opts ...decoder.JwsDecoderOption) decoder.TokenDecoder {
+	d := tc.newJwsDecoder(claimMappings, opts...)
 	return decoder.NewCachedJwtDecoder(Cache, d)
 }
 
 // UncachedServer creates an uncached server
-func (tc *TestConfig) UncachedServer(claimMappings map[string]string) *decoder.Server {
-	return decoder.NewServer(tc.newJwsDecoder(claimMappings), AuthHeaderKey, TokenValidatedHeaderKey, AuthHeaderRequired)
+func (tc *TestConfig) UncachedServer(claimMappings map[string]string, opts ...decoder.JwsDecoderOption) *decoder.Server {
+	return decoder.NewServer(tc.newJwsDecoder(claimMappings, opts...), AuthHeaderKey, TokenValidatedHeaderKey, AuthHeaderRequired)
 }
 
 // CachedServer creates a cached server
-func (tc *TestConfig) CachedServer(claimMappings map[string]string) *decoder.Server {
-	return decoder.NewServer(tc.newCachedDecoder(claimMappings), AuthHeaderKey, TokenValidatedHeaderKey, AuthHeaderRequired)
+func (tc *TestConfig) CachedServer(claimMappings map[string]string, opts ...decoder.JwsDecoderOption) *decoder.Server {
+	return decoder.NewServer(tc.newCachedDecoder(claimMappings, opts...), AuthHeaderKey, TokenValidatedHeaderKey, AuthHeaderRequired)
 }
 
 // Report the error message to testing if the condition is met
@@ -172,3 +247,62 @@ func Ctx() context.Context {
 func init() {
 	log.Logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout}).With().Timestamp().Caller().Logger().Level(zerolog.TraceLevel)
 }
+
+// DPoPKey is a client key to sign DPoP proofs with
+type DPoPKey struct {
+	privateKey *ecdsa.PrivateKey
+	publicKey  jwk.Key
+}
+
+// NewDPoPKey generates a new EC client key
+func NewDPoPKey() *DPoPKey {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	HandleByPanic(err)
+	pubKey, err := jwk.New(&privKey.PublicKey)
+	HandleByPanic(err)
+	return &DPoPKey{privateKey: privKey, publicKey: pubKey}
+}
+
+// Thumbprint returns the JWK SHA-256 thumbprint of the key as used in the cnf.jkt claim
+func (k *DPoPKey) Thumbprint() string {
+	thumbprint, err := k.publicKey.Thumbprint(crypto.SHA256)
+	HandleByPanic(err)
+	return base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+// ProofClaims returns the claims of a valid DPoP proof for the request and access token
+func ProofClaims(method, uri string, accessToken []byte) map[string]interface{} {
+	hash := sha256.Sum256(accessToken)
+	return map[string]interface{}{
+		"jti": strconv.FormatInt(mrand.Int63(), 10),
+		"htm": method,
+		"htu": uri,
+		"iat": time.Now().Unix(),
+		"ath": base64.RawURLEncoding.EncodeToString(hash[:]),
+	}
+}
+
+// NewProof signs a DPoP proof with the claims and the public key in the jwk header
+func (k *DPoPKey) NewProof(claims map[string]interface{}) []byte {
+	buf, err := json.Marshal(claims)
+	HandleByPanic(err)
+	h := jws.NewHeaders()
+	h.Set(jws.TypeKey, "dpop+jwt")
+	h.Set(jws.JWKKey, k.publicKey)
+	proof, err := jws.Sign(buf, jwa.ES256, k.privateKey, jws.WithHeaders(h))
+	HandleByPanic(err)
+	return proof
+}
+
+// NewClientCert generates a self signed client certificate and returns it encoded like the X-Forwarded-Tls-Client-Cert
+// header of Traefik and its SHA-256 thumbprint as used in the cnf.x5t#S256 claim
+func NewClientCert() (header string, thumbprint string) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	HandleByPanic(err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(mrand.Int63()), Subject: pkix.Name{CommonName: "workload"},
+		NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privKey.PublicKey, privKey)
+	HandleByPanic(err)
+	hash := sha256.Sum256(der)
+	return url.QueryEscape(base64.StdEncoding.EncodeToString(der)), base64.RawURLEncoding.EncodeToString(hash[:])
+}